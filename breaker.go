@@ -0,0 +1,172 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// breaker.go implements the per-peer circuit breakers of HTTPPool.
+
+package groupcache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultBreakerCooldown is how long a circuit stays open if
+// HTTPPool.BreakerCooldown is zero.
+const defaultBreakerCooldown = 10 * time.Second
+
+// CircuitState is the state of the circuit breaker guarding requests
+// to a peer.
+type CircuitState int
+
+const (
+	// CircuitClosed means the peer is healthy and picked as usual.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen means the peer has failed too often and is
+	// skipped: keys it owns go to the next peer on the ring, or
+	// are loaded locally.
+	CircuitOpen
+
+	// CircuitHalfOpen means the peer's cooldown has passed and a
+	// single probe request is allowed through to test whether it
+	// has recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler, so that the state is
+// reported by name in JSON.
+func (s CircuitState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *CircuitState) UnmarshalText(text []byte) error {
+	for _, state := range []CircuitState{CircuitClosed, CircuitOpen, CircuitHalfOpen} {
+		if string(text) == state.String() {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("groupcache: unknown circuit state %q", text)
+}
+
+// PeerStatus is a snapshot of a pool's view of one peer's health.
+type PeerStatus struct {
+	URL                 string        // the peer's base URL
+	State               CircuitState  // the peer's circuit
+	ConsecutiveFailures int           // failed requests since the last success
+	Latency             time.Duration // moving average of request latency
+	Requests            int64         // requests sent to the peer
+	Failures            int64         // requests that failed
+	Trips               int64         // times the circuit has opened
+}
+
+// breaker tracks the health of a peer. Its zero value is a closed
+// circuit.
+type breaker struct {
+	mu       sync.Mutex
+	state    CircuitState
+	failures int       // consecutive
+	openedAt time.Time // when the circuit last opened or probed
+	latency  time.Duration
+	requests int64
+	nfail    int64
+	trips    int64
+}
+
+// allow reports whether a request may be sent to the peer, given
+// the pool's cooldown. When an open circuit's cooldown has passed,
+// allow lets exactly one caller through as a probe. A probe that
+// never reports back is given up on after another cooldown.
+func (b *breaker) allow(now time.Time, cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitClosed {
+		return true
+	}
+	if now.Sub(b.openedAt) < cooldown {
+		return false
+	}
+	b.state = CircuitHalfOpen
+	b.openedAt = now
+	return true
+}
+
+// record accounts for a request to the peer that took d, opening
+// the circuit after threshold consecutive failures. A failure of a
+// half-open probe reopens the circuit immediately, and its success
+// closes it.
+func (b *breaker) record(ok bool, d time.Duration, now time.Time, threshold int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests++
+	if b.latency == 0 {
+		b.latency = d
+	} else {
+		// Exponentially weighted, giving the newest sample 1/8.
+		b.latency += (d - b.latency) / 8
+	}
+	if ok {
+		b.failures = 0
+		b.state = CircuitClosed
+		return
+	}
+	b.nfail++
+	b.failures++
+	if b.state == CircuitHalfOpen || threshold > 0 && b.failures >= threshold && b.state == CircuitClosed {
+		b.state = CircuitOpen
+		b.openedAt = now
+		b.trips++
+	}
+}
+
+// abandon notes that a request was cancelled by its caller before
+// the peer answered, which says nothing about the peer's health. A
+// cancelled probe frees the way for another.
+func (b *breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitHalfOpen {
+		b.openedAt = time.Time{}
+	}
+}
+
+func (b *breaker) status(url string) PeerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return PeerStatus{
+		URL:                 url,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Latency:             b.latency,
+		Requests:            b.requests,
+		Failures:            b.nfail,
+		Trips:               b.trips,
+	}
+}
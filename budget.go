@@ -0,0 +1,247 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"sync"
+
+	"github.com/golang/groupcache/evict"
+)
+
+// A Budget divides a fixed number of cache bytes among groups. Each
+// group starts with a share in proportion to its weight, and
+// Rebalance moves bytes toward the groups that would gain the most
+// hits from them.
+//
+// To estimate those hits, each group in a Budget remembers the keys
+// it most recently evicted, up to a quarter of the budget, and counts
+// the loads of those keys: the hits it would have had with more
+// bytes.
+//
+// A group's cache bytes are managed by the Budget while it belongs
+// to it; calling SetCacheBytes on the group meanwhile has no lasting
+// effect.
+type Budget struct {
+	mu      sync.Mutex
+	bytes   int64
+	members []*budgetMember
+}
+
+type budgetMember struct {
+	g      *Group
+	weight float64
+	alloc  int64
+
+	ghostHits int64 // the group's ghost hits at the last rebalance
+}
+
+// Rebalancing moves a step of 1/budgetSteps of the budget at a time,
+// and never leaves a group with less than 1/budgetFloor of its
+// weighted share. Groups remember evictions of up to 1/budgetGhosts
+// of the budget.
+const (
+	budgetSteps  = 32
+	budgetFloor  = 4
+	budgetGhosts = 4
+)
+
+// NewBudget returns a Budget dividing bytes among its groups.
+func NewBudget(bytes int64) *Budget {
+	return &Budget{bytes: bytes}
+}
+
+// Add adds g to the budget with the given weight, which must be
+// positive, and divides the budget afresh among its groups in
+// proportion to their weights.
+func (b *Budget) Add(g *Group, weight float64) {
+	if weight <= 0 {
+		panic("groupcache: Budget weight must be positive")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range b.members {
+		if m.g == g {
+			panic("groupcache: group " + g.name + " is already in the Budget")
+		}
+	}
+	m := &budgetMember{g: g, weight: weight}
+	g.ghosts.setMax(b.bytes / budgetGhosts)
+	m.ghostHits = g.ghosts.hitCount()
+	b.members = append(b.members, m)
+	b.reset()
+}
+
+// Remove removes g from the budget, dividing its bytes among the
+// remaining groups. g keeps its current cache bytes.
+func (b *Budget) Remove(g *Group) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, m := range b.members {
+		if m.g == g {
+			g.ghosts.setMax(0)
+			b.members = append(b.members[:i], b.members[i+1:]...)
+			b.reset()
+			return
+		}
+	}
+}
+
+// reset gives each group its weighted share of the budget.
+func (b *Budget) reset() {
+	var total float64
+	for _, m := range b.members {
+		total += m.weight
+	}
+	for _, m := range b.members {
+		m.setAlloc(int64(float64(b.bytes) * m.weight / total))
+	}
+}
+
+// Rebalance moves a step of bytes from the group that would lose
+// the fewest hits without them to the group that would gain the
+// most hits with them, judged by their ghost hits since the last
+// call. It should be called periodically, such as once a minute.
+//
+// A group's marginal value is its weight times its ghost hits. A
+// group with room to spare evicts nothing, so it has no ghost hits
+// and gives up bytes first; so does a group whose loads are of keys
+// it has never cached, as in a scan, since more bytes wouldn't turn
+// them into hits.
+func (b *Budget) Rebalance() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.members) < 2 {
+		return
+	}
+	var total float64
+	for _, m := range b.members {
+		total += m.weight
+	}
+	var donor, taker *budgetMember
+	var donorValue, takerValue float64
+	for _, m := range b.members {
+		hits := m.g.ghosts.hitCount()
+		value := m.weight * float64(hits-m.ghostHits)
+		m.ghostHits = hits
+
+		floor := int64(float64(b.bytes) * m.weight / total / budgetFloor)
+		if m.alloc > floor && (donor == nil || value < donorValue) {
+			donor, donorValue = m, value
+		}
+		if taker == nil || value > takerValue {
+			taker, takerValue = m, value
+		}
+	}
+	if donor == nil || donor == taker || takerValue <= donorValue {
+		return
+	}
+	step := b.bytes / budgetSteps
+	floor := int64(float64(b.bytes) * donor.weight / total / budgetFloor)
+	if donor.alloc-step < floor {
+		step = donor.alloc - floor
+	}
+	donor.setAlloc(donor.alloc - step)
+	taker.setAlloc(taker.alloc + step)
+}
+
+func (m *budgetMember) setAlloc(n int64) {
+	m.alloc = n
+	m.g.SetCacheBytes(n)
+}
+
+// Allocations returns the cache bytes the budget currently gives
+// each of its groups, by group name.
+func (b *Budget) Allocations() map[string]int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	allocs := make(map[string]int64, len(b.members))
+	for _, m := range b.members {
+		allocs[m.g.name] = m.alloc
+	}
+	return allocs
+}
+
+// ghostList remembers the keys a group recently evicted for lack of
+// room, up to a total size, and counts the loads of those keys.
+type ghostList struct {
+	mu     sync.Mutex
+	max    int64       // bytes of keys and values to remember; 0 to disable
+	nbytes int64       // of the keys and values remembered
+	keys   evict.Cache // of their sizes
+	hits   int64
+}
+
+// setMax sets the bytes of evicted entries gl remembers, forgetting
+// the oldest as need be.
+func (gl *ghostList) setMax(n int64) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	gl.max = n
+	if gl.keys == nil {
+		gl.keys = evict.LRU(func(key string, value interface{}) {
+			gl.nbytes -= value.(int64)
+		})
+	}
+	gl.trim()
+}
+
+func (gl *ghostList) trim() {
+	for gl.nbytes > gl.max && gl.keys.Len() > 0 {
+		gl.keys.RemoveOldest()
+	}
+}
+
+// add remembers the eviction of an entry of size bytes.
+func (gl *ghostList) add(key string, size int64) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	if gl.max == 0 || size > gl.max {
+		return
+	}
+	gl.keys.Remove(key)
+	gl.keys.Add(key, size)
+	gl.nbytes += size
+	gl.trim()
+}
+
+// load counts a load of key as a hit if it was recently evicted.
+func (gl *ghostList) load(key string) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	if gl.max == 0 {
+		return
+	}
+	if _, ok := gl.keys.Peek(key); ok {
+		gl.keys.Remove(key)
+		gl.hits++
+	}
+}
+
+// remove forgets key, which was removed rather than evicted.
+func (gl *ghostList) remove(key string) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	if gl.keys != nil {
+		gl.keys.Remove(key)
+	}
+}
+
+func (gl *ghostList) hitCount() int64 {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	return gl.hits
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBudget(t *testing.T) {
+	r := NewRegistry()
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString(strings.Repeat("x", 100-len(key)))
+	})
+	busy := r.newGroup("busy", 0, getter, NoPeers{})
+	idle := r.newGroup("idle", 0, getter, NoPeers{})
+	b := NewBudget(32000)
+	b.Add(busy, 1)
+	b.Add(idle, 1)
+	if got := b.Allocations(); got["busy"] != 16000 || got["idle"] != 16000 {
+		t.Fatalf("initial allocations = %v; want 16000 each", got)
+	}
+
+	// busy cycles through 20000 bytes of values, which don't fit in
+	// its share, while idle rereads one cached value.
+	var s string
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 200; i++ {
+			busy.Get(dummyCtx, fmt.Sprintf("k%03d", i), StringSink(&s))
+		}
+		idle.Get(dummyCtx, "k", StringSink(&s))
+		b.Rebalance()
+	}
+	got := b.Allocations()
+	if got["busy"]+got["idle"] != 32000 {
+		t.Errorf("allocations = %v; want a total of 32000", got)
+	}
+	if got["busy"] < 20000 || got["idle"] >= 16000 {
+		t.Errorf("allocations = %v; want busy to have grown to hold its 20000 bytes", got)
+	}
+	if n := busy.CacheBytes(); n != got["busy"] {
+		t.Errorf("busy group's CacheBytes = %d; want its allocation, %d", n, got["busy"])
+	}
+	loads := busy.Stats.Loads.Get()
+	for i := 0; i < 200; i++ {
+		busy.Get(dummyCtx, fmt.Sprintf("k%03d", i), StringSink(&s))
+	}
+	if n := busy.Stats.Loads.Get() - loads; n != 0 {
+		t.Errorf("busy group loaded %d keys after rebalancing; want 0", n)
+	}
+
+	b.Remove(idle)
+	if got := b.Allocations(); len(got) != 1 || got["busy"] != 32000 {
+		t.Errorf("allocations after Remove = %v; want busy: 32000", got)
+	}
+}
+
+// tests that a group scanning keys it never rereads, however many
+// misses it has, doesn't take bytes from a group that would hit.
+func TestBudgetScan(t *testing.T) {
+	r := NewRegistry()
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString(strings.Repeat("x", 100-len(key)))
+	})
+	scan := r.newGroup("scan", 0, getter, NoPeers{})
+	reuse := r.newGroup("reuse", 0, getter, NoPeers{})
+	b := NewBudget(32000)
+	b.Add(scan, 1)
+	b.Add(reuse, 1)
+
+	var s string
+	key := 0
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 1000; i++ {
+			scan.Get(dummyCtx, fmt.Sprintf("s%07d", key), StringSink(&s))
+			key++
+		}
+		for i := 0; i < 200; i++ {
+			reuse.Get(dummyCtx, fmt.Sprintf("k%03d", i), StringSink(&s))
+		}
+		b.Rebalance()
+	}
+	if got := b.Allocations(); got["scan"] >= 16000 || got["reuse"] < 20000 {
+		t.Errorf("allocations = %v; want bytes moved from scan to reuse", got)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"io"
 	"strings"
+	"time"
 )
 
 // A ByteView holds an immutable view of bytes.
@@ -33,6 +34,26 @@ type ByteView struct {
 	// If b is non-nil, b is used, else s is used.
 	b []byte
 	s string
+
+	// e is the time after which the view is no longer valid.
+	// The zero value means the view never expires.
+	e time.Time
+
+	// z, if non-nil, is the Codec the bytes are encoded with. Only
+	// views held in a group's caches are encoded; they are decoded
+	// before being handed to callers.
+	z Codec
+}
+
+// Expire returns the time after which the view's data expires,
+// or the zero Time if it never expires.
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
+// expired reports whether v has an expiry time at or before now.
+func (v ByteView) expired(now time.Time) bool {
+	return !v.e.IsZero() && !now.Before(v.e)
 }
 
 // Len returns the view's length.
@@ -70,17 +91,17 @@ func (v ByteView) At(i int) byte {
 // Slice slices the view between the provided from and to indices.
 func (v ByteView) Slice(from, to int) ByteView {
 	if v.b != nil {
-		return ByteView{b: v.b[from:to]}
+		return ByteView{b: v.b[from:to], e: v.e}
 	}
-	return ByteView{s: v.s[from:to]}
+	return ByteView{s: v.s[from:to], e: v.e}
 }
 
 // SliceFrom slices the view from the provided index until the end.
 func (v ByteView) SliceFrom(from int) ByteView {
 	if v.b != nil {
-		return ByteView{b: v.b[from:]}
+		return ByteView{b: v.b[from:], e: v.e}
 	}
-	return ByteView{s: v.s[from:]}
+	return ByteView{s: v.s[from:], e: v.e}
 }
 
 // Copy copies b into dest and returns the number of bytes copied.
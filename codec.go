@@ -0,0 +1,162 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// A Codec compresses the values a Group caches. Values are held
+// in the group's caches and disk tier encoded, counting their encoded
+// size against the group's cache bytes, and decoded when they are
+// delivered to a Sink. Peers whose groups use Codecs of the same name
+// exchange values encoded.
+//
+// A Codec must be safe for concurrent use.
+type Codec interface {
+	// Name identifies the encoding to peers, as in "gzip".
+	Name() string
+
+	// Encode returns b encoded.
+	Encode(b []byte) ([]byte, error)
+
+	// Decode returns the bytes encoded in b.
+	Decode(b []byte) ([]byte, error)
+}
+
+// GzipCodec is a Codec encoding values in the gzip format.
+type GzipCodec struct {
+	// Level is the gzip compression level. If zero,
+	// gzip.DefaultCompression is used.
+	Level int
+}
+
+func (GzipCodec) Name() string { return "gzip" }
+
+func (c GzipCodec) Encode(b []byte) ([]byte, error) {
+	return encode(b, func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, compressionLevel(c.Level))
+	})
+}
+
+func (GzipCodec) Decode(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// FlateCodec is a Codec encoding values as raw DEFLATE data, without
+// the gzip header and checksum.
+type FlateCodec struct {
+	// Level is the flate compression level. If zero,
+	// flate.DefaultCompression is used.
+	Level int
+}
+
+func (FlateCodec) Name() string { return "deflate" }
+
+func (c FlateCodec) Encode(b []byte) ([]byte, error) {
+	return encode(b, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, compressionLevel(c.Level))
+	})
+}
+
+func (FlateCodec) Decode(b []byte) ([]byte, error) {
+	return ioutil.ReadAll(flate.NewReader(bytes.NewReader(b)))
+}
+
+func compressionLevel(level int) int {
+	if level == 0 {
+		return flate.DefaultCompression
+	}
+	return level
+}
+
+// encode returns b compressed by a writer from newWriter.
+func encode(b []byte, newWriter func(io.Writer) (io.WriteCloser, error)) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := newWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encode returns value encoded with the group's Codec for caching. A
+// value the Codec fails on, or doesn't make smaller, is returned as
+// is.
+func (g *Group) encode(value ByteView) ByteView {
+	if g.Codec == nil || value.z != nil {
+		return value
+	}
+	b := value.b
+	if b == nil {
+		b = []byte(value.s)
+	}
+	enc, err := g.Codec.Encode(b)
+	if err != nil || len(enc) >= len(b) {
+		return value
+	}
+	return ByteView{b: enc, e: value.e, z: g.Codec}
+}
+
+// decode returns v with its bytes decoded, if they are encoded.
+func (v ByteView) decode() (ByteView, error) {
+	if v.z == nil {
+		return v, nil
+	}
+	b, err := v.z.Decode(v.b)
+	if err != nil {
+		return ByteView{}, fmt.Errorf("groupcache: decoding %s value: %v", v.z.Name(), err)
+	}
+	return ByteView{b: b, e: v.e}, nil
+}
+
+// peerEncoding returns the Codec for a value a peer sent with the
+// named encoding, or nil for an unencoded value.
+func (g *Group) peerEncoding(name string) (Codec, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if g.Codec == nil || g.Codec.Name() != name {
+		return nil, fmt.Errorf("groupcache: peer sent value with unrequested encoding %q", name)
+	}
+	return g.Codec, nil
+}
+
+// acceptEncoding returns the encoding the group asks peers to send
+// values in, or nil.
+func (g *Group) acceptEncoding() *string {
+	if g.Codec == nil {
+		return nil
+	}
+	name := g.Codec.Name()
+	return &name
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"math/rand"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/golang/groupcache/groupcachepb"
+)
+
+func TestCodecs(t *testing.T) {
+	for _, c := range []Codec{GzipCodec{}, GzipCodec{Level: 1}, FlateCodec{}, FlateCodec{Level: 9}} {
+		for _, in := range []string{"", "x", strings.Repeat("groupcache ", 100)} {
+			enc, err := c.Encode([]byte(in))
+			if err != nil {
+				t.Errorf("%s: Encode(%q): %v", c.Name(), in, err)
+				continue
+			}
+			dec, err := c.Decode(enc)
+			if err != nil || string(dec) != in {
+				t.Errorf("%s: Decode(Encode(%q)) = %q, %v", c.Name(), in, dec, err)
+			}
+		}
+		if _, err := c.Decode([]byte("not compressed")); err == nil {
+			t.Errorf("%s: Decode of garbage succeeded", c.Name())
+		}
+	}
+}
+
+func TestGroupCodec(t *testing.T) {
+	noise := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(noise)
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		if key == "noise" {
+			return dest.SetBytes(noise)
+		}
+		return dest.SetString(strings.Repeat("groupcache ", 100) + key)
+	})
+	g := NewRegistry().NewGroupWithOptions("TestGroupCodec-group", 1<<20, getter, GroupOptions{
+		Peers: NoPeers{},
+		Codec: GzipCodec{},
+	})
+	want := strings.Repeat("groupcache ", 100) + "key"
+	for i := 0; i < 2; i++ {
+		var s string
+		if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil || s != want {
+			t.Fatalf("Get %d = %q, %v", i, s, err)
+		}
+	}
+	if g.Stats.CacheHits.Get() != 1 {
+		t.Errorf("CacheHits = %d; want 1", g.Stats.CacheHits.Get())
+	}
+	if n := g.mainCache.bytes(); n >= int64(len(want))/4 {
+		t.Errorf("cache holds %d bytes for a %d byte value; want it compressed", n, len(want))
+	}
+	var b []byte
+	if err := g.Get(dummyCtx, "noise", AllocatingByteSliceSink(&b)); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := g.mainCache.get("noise", 0); !ok || v.z != nil {
+		t.Errorf("incompressible value cached as %+v, %v; want unencoded", v, ok)
+	}
+	b = nil
+	if err := g.Get(dummyCtx, "noise", AllocatingByteSliceSink(&b)); err != nil || string(b) != string(noise) {
+		t.Errorf("Get(noise) = %d bytes, %v", len(b), err)
+	}
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	value := strings.Repeat("groupcache ", 100)
+	server := NewRegistry()
+	serverPool := server.NewHTTPPool("http://server")
+	sg := server.NewGroupWithOptions("codec-test", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString(value + key)
+	}), GroupOptions{Codec: GzipCodec{}})
+	srv := httptest.NewServer(serverPool)
+	defer srv.Close()
+	var s string
+	if err := sg.Get(context.Background(), "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewRegistry()
+	clientPool := client.NewHTTPPool("http://client")
+	clientPool.Set(srv.URL)
+	h := clientPool.httpGetters[srv.URL]
+	for _, accept := range []string{"", "gzip", "deflate"} {
+		req := &pb.GetRequest{Group: proto.String("codec-test"), Key: proto.String("key")}
+		if accept != "" {
+			req.AcceptEncoding = proto.String(accept)
+		}
+		res := &pb.GetResponse{}
+		if err := h.Get(context.Background(), req, res); err != nil {
+			t.Fatal(err)
+		}
+		got := string(res.Value)
+		if accept == "gzip" {
+			if res.GetEncoding() != "gzip" {
+				t.Fatalf("accepting gzip, encoding = %q", res.GetEncoding())
+			}
+			b, err := GzipCodec{}.Decode(res.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = string(b)
+		} else if res.Encoding != nil {
+			t.Errorf("accepting %q, encoding = %q", accept, res.GetEncoding())
+		}
+		if got != value+"key" {
+			t.Errorf("accepting %q, value = %q", accept, got)
+		}
+	}
+
+	cg := client.NewGroupWithOptions("codec-test", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		t.Error("client Getter called")
+		return dest.SetString("")
+	}), GroupOptions{
+		Codec:          GzipCodec{},
+		HotCachePolicy: FixedHotCachePolicy{Mirror: true, HotRatio: 1},
+	})
+	before := clientPool.PeerStats()[0].ResponseBytes.Sum
+	for i := 0; i < 2; i++ {
+		if err := cg.Get(context.Background(), "key", StringSink(&s)); err != nil || s != value+"key" {
+			t.Fatalf("client Get %d = %q, %v", i, s, err)
+		}
+	}
+	if n := clientPool.PeerStats()[0].ResponseBytes.Sum - before; n >= int64(len(value))/4 {
+		t.Errorf("client received %d bytes for a %d byte value; want it compressed", n, len(value))
+	}
+	if v, ok := cg.hotCache.get("key", 0); !ok || v.z == nil {
+		t.Errorf("hot cache holds %+v, %v; want the encoded value", v, ok)
+	}
+	if cg.Stats.CacheHits.Get() != 1 {
+		t.Errorf("client CacheHits = %d; want 1", cg.Stats.CacheHits.Get())
+	}
+}
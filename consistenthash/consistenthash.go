@@ -79,3 +79,25 @@ func (m *Map) Get(key string) string {
 	// Means we have cycled back to the first replica.
 	return m.hashMap[m.keys[0]]
 }
+
+// Gets up to n distinct items for the provided key, in order around
+// the ring starting with the closest, which is the one Get returns.
+func (m *Map) GetN(key string, n int) []string {
+	if m.IsEmpty() || n <= 0 {
+		return nil
+	}
+
+	hash := int(m.hash([]byte(key)))
+	start := sort.SearchInts(m.keys, hash)
+
+	var items []string
+	seen := make(map[string]bool)
+	for i := 0; i < len(m.keys) && len(items) < n; i++ {
+		item := m.hashMap[m.keys[(start+i)%len(m.keys)]]
+		if !seen[item] {
+			seen[item] = true
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package consistenthash
 
 import (
+	"fmt"
 	"strconv"
 	"testing"
 )
@@ -64,6 +65,41 @@ func TestHashing(t *testing.T) {
 
 }
 
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, err := strconv.Atoi(string(key))
+		if err != nil {
+			panic(err)
+		}
+		return uint32(i)
+	})
+
+	// Replicas with "hashes": 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+
+	testCases := []struct {
+		key  string
+		n    int
+		want string
+	}{
+		{"2", 1, "[2]"},
+		{"3", 2, "[4 6]"},
+		{"11", 3, "[2 4 6]"},
+		{"25", 3, "[6 2 4]"},
+		{"27", 5, "[2 4 6]"},
+		{"27", 0, "[]"},
+	}
+	for _, tc := range testCases {
+		got := hash.GetN(tc.key, tc.n)
+		if fmt.Sprint(got) != tc.want {
+			t.Errorf("GetN(%s, %d) = %v; want %s", tc.key, tc.n, got, tc.want)
+		}
+		if len(got) > 0 && got[0] != hash.Get(tc.key) {
+			t.Errorf("GetN(%s, %d)[0] = %s; Get returned %s", tc.key, tc.n, got[0], hash.Get(tc.key))
+		}
+	}
+}
+
 func TestConsistency(t *testing.T) {
 	hash1 := New(1, nil)
 	hash2 := New(1, nil)
@@ -0,0 +1,183 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/groupcache/evict"
+)
+
+// diskCacheSuffix ends the names of the files a DiskCache writes.
+const diskCacheSuffix = ".gcdisk"
+
+// A DiskCache is a second cache tier for a Group, holding the
+// entries its main cache evicts for lack of room in files on local
+// disk. Its entries are kept within its own byte budget, evicting the
+// least recently used.
+//
+// A DiskCache's index is kept in memory, so its entries don't outlive
+// the process. A DiskCache must not be shared between groups.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries evict.Cache // of *diskEntry
+	seq     uint64      // numbers the files
+	nbytes  int64       // of all keys and values
+	nevict  int64
+
+	nget, nhit int64 // accessed atomically
+}
+
+type diskEntry struct {
+	file   string
+	size   int64 // of the key and value
+	expire time.Time
+	codec  Codec // the value is encoded with, if any
+}
+
+// NewDiskCache returns a DiskCache keeping at most maxBytes of keys
+// and values in files in dir, which is created if need be. Files
+// left in dir by an earlier DiskCache are removed.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	stale, err := filepath.Glob(filepath.Join(dir, "*"+diskCacheSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range stale {
+		if err := os.Remove(name); err != nil {
+			return nil, err
+		}
+	}
+	d := &DiskCache{dir: dir, maxBytes: maxBytes}
+	d.entries = evict.LRU(func(key string, value interface{}) {
+		e := value.(*diskEntry)
+		d.nbytes -= e.size
+		d.nevict++
+		os.Remove(e.file)
+	})
+	return d, nil
+}
+
+// add writes value to disk as key's, evicting older entries to make
+// room. Failures to write are ignored: the entry is simply not
+// cached. A value that isn't cached still removes any older entry for
+// key, which would otherwise be served in its place.
+func (d *DiskCache) add(key string, value ByteView) {
+	size := int64(len(key) + value.Len())
+	if size > d.maxBytes || value.expired(time.Now()) {
+		d.remove(key)
+		return
+	}
+	d.mu.Lock()
+	d.seq++
+	file := filepath.Join(d.dir, strconv.FormatUint(d.seq, 10)+diskCacheSuffix)
+	d.mu.Unlock()
+
+	if err := writeValue(file, value); err != nil {
+		os.Remove(file)
+		d.remove(key)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if old, ok := d.entries.Peek(key); ok {
+		old := old.(*diskEntry)
+		d.nbytes -= old.size
+		os.Remove(old.file)
+	}
+	d.entries.Add(key, &diskEntry{file: file, size: size, expire: value.e, codec: value.z})
+	d.nbytes += size
+	for d.nbytes > d.maxBytes && d.entries.Len() > 0 {
+		d.entries.RemoveOldest()
+	}
+}
+
+func writeValue(file string, value ByteView) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if value.b != nil {
+		_, err = f.Write(value.b)
+	} else {
+		_, err = io.WriteString(f, value.s)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// get returns key's value, if it is on disk and expired no more than
+// stale ago.
+func (d *DiskCache) get(key string, stale time.Duration) (value ByteView, ok bool) {
+	atomic.AddInt64(&d.nget, 1)
+	d.mu.Lock()
+	ei, ok := d.entries.Get(key)
+	if !ok {
+		d.mu.Unlock()
+		return
+	}
+	e := ei.(*diskEntry)
+	if !e.expire.IsZero() && time.Now().Add(-stale).After(e.expire) {
+		d.entries.Remove(key)
+		d.mu.Unlock()
+		return ByteView{}, false
+	}
+	d.mu.Unlock()
+
+	// The entry may be evicted and its file removed before we
+	// read it, which is a miss.
+	b, err := ioutil.ReadFile(e.file)
+	if err != nil {
+		return ByteView{}, false
+	}
+	atomic.AddInt64(&d.nhit, 1)
+	return ByteView{b: b, e: e.expire, z: e.codec}, true
+}
+
+func (d *DiskCache) remove(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries.Remove(key)
+}
+
+func (d *DiskCache) stats() CacheStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return CacheStats{
+		Bytes:     d.nbytes,
+		Items:     int64(d.entries.Len()),
+		Gets:      atomic.LoadInt64(&d.nget),
+		Hits:      atomic.LoadInt64(&d.nhit),
+		Evictions: d.nevict,
+	}
+}
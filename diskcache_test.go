@@ -0,0 +1,195 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := NewDiskCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loads int64
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		atomic.AddInt64(&loads, 1)
+		return dest.SetString(key + strings.Repeat("x", 10))
+	})
+	g := NewRegistry().NewGroupWithOptions("TestDiskCache-group", 100, getter, GroupOptions{
+		Peers:     NoPeers{},
+		DiskCache: disk,
+	})
+	var s string
+	for i := 0; i < 20; i++ {
+		g.Get(dummyCtx, strconv.Itoa(i), StringSink(&s))
+	}
+	main, tier := g.CacheStats(MainCache), g.CacheStats(DiskTier)
+	if main.Items+tier.Items != 20 || tier.Items == 0 {
+		t.Fatalf("main cache holds %d items and disk %d; want 20 between them", main.Items, tier.Items)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+diskCacheSuffix))
+	if int64(len(files)) != tier.Items {
+		t.Errorf("disk cache has %d files for %d items", len(files), tier.Items)
+	}
+
+	// An evicted key is found on disk and moved back to the main cache.
+	if err := g.Get(dummyCtx, "0", StringSink(&s)); err != nil || s != "0xxxxxxxxxx" {
+		t.Fatalf("Get(0) = %q, %v", s, err)
+	}
+	if loads != 20 {
+		t.Errorf("loads = %d; want 20, the disk tier serving the evicted key", loads)
+	}
+	if _, ok := g.mainCache.get("0", 0); !ok {
+		t.Error("disk hit not moved to the main cache")
+	}
+	if got := g.CacheStats(DiskTier); got.Hits != 1 || got.Items != tier.Items {
+		t.Errorf("after disk hit, disk stats = %+v; want 1 hit and %d items", got, tier.Items)
+	}
+
+	// Remove clears every tier.
+	g.Remove(dummyCtx, "1")
+	if _, ok := disk.get("1", 0); ok {
+		t.Error("removed key still on disk")
+	}
+	g.Get(dummyCtx, "1", StringSink(&s))
+	if loads != 21 {
+		t.Errorf("loads = %d; want 21 after Remove", loads)
+	}
+}
+
+// tests that a stale entry found on disk is still served while its
+// refresh fails.
+func TestDiskCacheStale(t *testing.T) {
+	disk, err := NewDiskCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var staleLoads int64
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		if key != "stale" {
+			return dest.SetString(strings.Repeat("x", 10))
+		}
+		if atomic.AddInt64(&staleLoads, 1) > 1 {
+			return errors.New("origin down")
+		}
+		dest.SetExpiry(time.Now().Add(20 * time.Millisecond))
+		return dest.SetString("old value")
+	})
+	g := NewRegistry().NewGroupWithOptions("TestDiskCacheStale-group", 100, getter, GroupOptions{
+		Peers:                NoPeers{},
+		DiskCache:            disk,
+		StaleWhileRevalidate: time.Hour,
+	})
+	var s string
+	g.Get(dummyCtx, "stale", StringSink(&s))
+	for i := 0; i < 20; i++ {
+		g.Get(dummyCtx, strconv.Itoa(i), StringSink(&s))
+	}
+	if _, ok := disk.get("stale", time.Hour); !ok {
+		t.Fatal("entry not spilled to disk")
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		if err := g.Get(dummyCtx, "stale", StringSink(&s)); err != nil || s != "old value" {
+			t.Fatalf("Get %d of stale key = %q, %v; want the old value", i, s, err)
+		}
+		for atomic.LoadInt64(&staleLoads) < 2 {
+			time.Sleep(time.Millisecond) // await the failing refresh
+		}
+	}
+}
+
+// tests that a Set replaces a value spilled to disk, rather than
+// leaving it to be served once the new value expires.
+func TestDiskCacheSet(t *testing.T) {
+	disk, err := NewDiskCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loads int64
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		atomic.AddInt64(&loads, 1)
+		return dest.SetString(key + strings.Repeat("x", 10))
+	})
+	g := NewRegistry().NewGroupWithOptions("TestDiskCacheSet-group", 100, getter, GroupOptions{
+		Peers:     NoPeers{},
+		DiskCache: disk,
+	})
+	var s string
+	g.Get(dummyCtx, "k", StringSink(&s))
+	for i := 0; i < 20; i++ {
+		g.Get(dummyCtx, strconv.Itoa(i), StringSink(&s))
+	}
+	if _, ok := disk.get("k", 0); !ok {
+		t.Fatal("entry not spilled to disk")
+	}
+	if err := g.Set(dummyCtx, "k", []byte("v2"), time.Now().Add(20*time.Millisecond), false); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	before := atomic.LoadInt64(&loads)
+	if err := g.Get(dummyCtx, "k", StringSink(&s)); err != nil || s != "kxxxxxxxxxx" {
+		t.Fatalf("Get(k) = %q, %v; want a fresh load", s, err)
+	}
+	if atomic.LoadInt64(&loads) != before+1 {
+		t.Error("Get of an expired Set served the value it replaced from disk")
+	}
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := NewDiskCache(dir, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		disk.add(strconv.Itoa(i), ByteView{s: strings.Repeat("x", 9)})
+	}
+	st := disk.stats()
+	if st.Bytes > 50 || st.Items != 5 || st.Evictions != 5 {
+		t.Errorf("stats = %+v; want 5 items within 50 bytes and 5 evictions", st)
+	}
+	if _, ok := disk.get("0", 0); ok {
+		t.Error("oldest entry not evicted")
+	}
+	if v, ok := disk.get("9", 0); !ok || v.String() != "xxxxxxxxx" {
+		t.Errorf("get(9) = %q, %v", v.String(), ok)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+diskCacheSuffix))
+	if len(files) != 5 {
+		t.Errorf("%d files left on disk; want 5", len(files))
+	}
+
+	// A new DiskCache in the same directory starts empty.
+	if _, err := NewDiskCache(dir, 50); err != nil {
+		t.Fatal(err)
+	}
+	files, _ = filepath.Glob(filepath.Join(dir, "*"+diskCacheSuffix))
+	if len(files) != 0 {
+		t.Errorf("%d stale files left on disk", len(files))
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package evict
+
+import "container/list"
+
+// ARC is a Policy implementing the Adaptive Replacement Cache of
+// Megiddo and Modha. It splits its entries between those used once
+// recently and those used more often, and remembers the keys of
+// recently evicted entries to learn which of the two to favor.
+//
+// Since the cache is bounded by its owner, it takes its capacity to
+// be the most entries it has held when asked to evict one.
+func ARC(onEvicted func(key string, value interface{})) Cache {
+	return &arc{
+		onEvicted: onEvicted,
+		items:     make(map[string]*list.Element),
+		t1:        list.New(),
+		t2:        list.New(),
+		b1:        list.New(),
+		b2:        list.New(),
+	}
+}
+
+type arc struct {
+	onEvicted func(key string, value interface{})
+	items     map[string]*list.Element // entries in all four lists
+
+	t1, t2 *list.List // cached entries used once, and more than once
+	b1, b2 *list.List // ghosts of entries evicted from t1 and t2
+
+	c int // capacity
+	p int // target size of t1
+}
+
+func (c *arc) list(seg segment) *list.List {
+	switch seg {
+	case t1:
+		return c.t1
+	case t2:
+		return c.t2
+	case b1:
+		return c.b1
+	}
+	return c.b2
+}
+
+// move moves the entry in ele to the front of seg's list.
+func (c *arc) move(ele *list.Element, seg segment) {
+	e := ele.Value.(*entry)
+	c.list(e.seg).Remove(ele)
+	e.seg = seg
+	c.items[e.key] = c.list(seg).PushFront(e)
+}
+
+func (c *arc) Add(key string, value interface{}) {
+	ele, ok := c.items[key]
+	if !ok {
+		c.items[key] = c.t1.PushFront(&entry{key, value, t1})
+		return
+	}
+	e := ele.Value.(*entry)
+	e.value = value
+	switch e.seg {
+	case b1:
+		// t1 was too small to keep the entry.
+		c.p = min(c.c, c.p+max(c.b2.Len()/c.b1.Len(), 1))
+	case b2:
+		// t2 was too small to keep the entry.
+		c.p = max(0, c.p-max(c.b1.Len()/c.b2.Len(), 1))
+	}
+	c.move(ele, t2)
+}
+
+func (c *arc) Get(key string) (value interface{}, ok bool) {
+	ele, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*entry)
+	if e.seg == b1 || e.seg == b2 {
+		return nil, false
+	}
+	c.move(ele, t2)
+	return e.value, true
+}
+
+func (c *arc) Peek(key string) (value interface{}, ok bool) {
+	return peek(c.items, key)
+}
+
+func (c *arc) Remove(key string) {
+	ele, ok := c.items[key]
+	if !ok {
+		return
+	}
+	e := ele.Value.(*entry)
+	c.list(e.seg).Remove(ele)
+	delete(c.items, key)
+	if (e.seg == t1 || e.seg == t2) && c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
+
+func (c *arc) RemoveOldest() {
+	if c.c < c.Len() {
+		c.c = c.Len()
+	}
+	var ele *list.Element
+	var ghost segment
+	if c.t1.Len() > 0 && (c.t1.Len() > c.p || c.t2.Len() == 0) {
+		ele, ghost = c.t1.Back(), b1
+	} else if c.t2.Len() > 0 {
+		ele, ghost = c.t2.Back(), b2
+	} else {
+		return
+	}
+	e := ele.Value.(*entry)
+	value := e.value
+	e.value = nil
+	c.move(ele, ghost)
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, value)
+	}
+
+	// Forget the oldest ghosts beyond the cache's capacity.
+	for c.b1.Len() > 0 && c.t1.Len()+c.b1.Len() > c.c {
+		c.forget(c.b1.Back())
+	}
+	for c.b2.Len() > 0 && c.Len()+c.b1.Len()+c.b2.Len() > 2*c.c {
+		c.forget(c.b2.Back())
+	}
+}
+
+func (c *arc) forget(ele *list.Element) {
+	e := ele.Value.(*entry)
+	c.list(e.seg).Remove(ele)
+	delete(c.items, e.key)
+}
+
+func (c *arc) Len() int {
+	return c.t1.Len() + c.t2.Len()
+}
+
+func (c *arc) Walk(fn func(key string, value interface{})) {
+	walkLists(fn, c.t1, c.t2)
+}
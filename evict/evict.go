@@ -0,0 +1,168 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package evict implements caches with a choice of eviction policies.
+//
+// The caches grow without bound: they evict an entry only when their
+// owner calls RemoveOldest. This lets the owner bound a cache by
+// something other than its number of entries, such as the bytes its
+// values use.
+package evict
+
+import "container/list"
+
+// Cache is a cache whose eviction order is decided by a policy. It is
+// not safe for concurrent access.
+type Cache interface {
+	// Add adds a value to the cache, replacing any value already
+	// held for key.
+	Add(key string, value interface{})
+
+	// Get looks up a key's value from the cache.
+	Get(key string) (value interface{}, ok bool)
+
+	// Peek is like Get, but doesn't count as a use of the key.
+	Peek(key string) (value interface{}, ok bool)
+
+	// Remove removes the provided key from the cache.
+	Remove(key string)
+
+	// RemoveOldest evicts the entry the policy values least.
+	RemoveOldest()
+
+	// Len returns the number of items in the cache.
+	Len() int
+
+	// Walk calls fn for each entry in the cache, starting with the
+	// one the policy values least, so that adding the entries to
+	// an empty cache in the same order approximately recreates it.
+	// fn must not modify the cache.
+	Walk(fn func(key string, value interface{}))
+}
+
+// A Policy creates an empty Cache. If onEvicted is non-nil, the cache
+// calls it with each entry that is evicted or removed, but not with
+// values that are replaced.
+type Policy func(onEvicted func(key string, value interface{})) Cache
+
+// LRU is a Policy that evicts the least recently used entry.
+func LRU(onEvicted func(key string, value interface{})) Cache {
+	return &lru{
+		onEvicted: onEvicted,
+		items:     make(map[string]*list.Element),
+		ll:        list.New(),
+	}
+}
+
+type lru struct {
+	onEvicted func(key string, value interface{})
+	items     map[string]*list.Element
+	ll        *list.List
+}
+
+func (c *lru) Add(key string, value interface{}) {
+	if ele, ok := c.items[key]; ok {
+		c.ll.MoveToFront(ele)
+		ele.Value.(*entry).value = value
+		return
+	}
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value})
+}
+
+func (c *lru) Get(key string) (value interface{}, ok bool) {
+	if ele, ok := c.items[key]; ok {
+		c.ll.MoveToFront(ele)
+		return ele.Value.(*entry).value, true
+	}
+	return nil, false
+}
+
+func (c *lru) Peek(key string) (value interface{}, ok bool) {
+	return peek(c.items, key)
+}
+
+func (c *lru) Remove(key string) {
+	if ele, ok := c.items[key]; ok {
+		c.removeElement(ele)
+	}
+}
+
+func (c *lru) RemoveOldest() {
+	if ele := c.ll.Back(); ele != nil {
+		c.removeElement(ele)
+	}
+}
+
+func (c *lru) removeElement(ele *list.Element) {
+	e := c.ll.Remove(ele).(*entry)
+	delete(c.items, e.key)
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
+
+func (c *lru) Len() int {
+	return len(c.items)
+}
+
+func (c *lru) Walk(fn func(key string, value interface{})) {
+	walkLists(fn, c.ll)
+}
+
+// entry is a cache entry held in one of a cache's lists.
+type entry struct {
+	key   string
+	value interface{}
+	seg   segment // the list holding the entry
+}
+
+// segment identifies one of the lists making up a cache.
+type segment int
+
+const (
+	window segment = iota
+	probation
+	protected
+	t1 // ARC's recency list
+	t2 // ARC's frequency list
+	b1 // ARC's ghosts of entries evicted from t1
+	b2 // ARC's ghosts of entries evicted from t2
+)
+
+// peek looks up key's value in a cache whose entries are held in
+// items, which may include ghosts of evicted entries.
+func peek(items map[string]*list.Element, key string) (value interface{}, ok bool) {
+	ele, ok := items[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*entry)
+	if e.seg == b1 || e.seg == b2 {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// walkLists calls fn for each entry in lists, in turn, from the back
+// of each list to its front.
+func walkLists(fn func(key string, value interface{}), lists ...*list.List) {
+	for _, l := range lists {
+		for ele := l.Back(); ele != nil; ele = ele.Prev() {
+			e := ele.Value.(*entry)
+			fn(e.key, e.value)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package evict
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+var policies = []struct {
+	name   string
+	policy Policy
+}{
+	{"LRU", LRU},
+	{"LFU", LFU},
+	{"SLRU", SLRU},
+	{"ARC", ARC},
+	{"TinyLFU", TinyLFU},
+}
+
+func TestCache(t *testing.T) {
+	for _, p := range policies {
+		var evicted []string
+		c := p.policy(func(key string, value interface{}) {
+			evicted = append(evicted, fmt.Sprintf("%s=%v", key, value))
+		})
+		c.RemoveOldest() // no-op when empty
+		for i := 0; i < 10; i++ {
+			c.Add(strconv.Itoa(i), i)
+		}
+		c.Add("3", 33)
+		if v, ok := c.Get("3"); !ok || v != 33 {
+			t.Errorf("%s: Get(3) = %v, %v; want 33, true", p.name, v, ok)
+		}
+		if v, ok := c.Peek("3"); !ok || v != 33 {
+			t.Errorf("%s: Peek(3) = %v, %v; want 33, true", p.name, v, ok)
+		}
+		if _, ok := c.Get("nonsense"); ok {
+			t.Errorf("%s: Get(nonsense) hit", p.name)
+		}
+		c.Remove("5")
+		if _, ok := c.Get("5"); ok {
+			t.Errorf("%s: Get of removed key hit", p.name)
+		}
+		if got := fmt.Sprint(evicted); got != "[5=5]" {
+			t.Errorf("%s: evicted %v; want [5=5]", p.name, got)
+		}
+		if c.Len() != 9 {
+			t.Errorf("%s: Len = %d; want 9", p.name, c.Len())
+		}
+		for c.Len() > 0 {
+			c.RemoveOldest()
+		}
+		if len(evicted) != 10 {
+			t.Errorf("%s: evicted %d entries; want 10", p.name, len(evicted))
+		}
+		if _, ok := c.Get("3"); ok {
+			t.Errorf("%s: Get(3) hit in empty cache", p.name)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	for _, p := range policies {
+		c := p.policy(nil)
+		for i := 0; i < 10; i++ {
+			c.Add(strconv.Itoa(i), i)
+		}
+		c.Get("2")
+		c.Remove("5")
+		var keys []string
+		seen := map[string]bool{}
+		c.Walk(func(key string, value interface{}) {
+			if value != mustAtoi(key) || seen[key] {
+				t.Errorf("%s: Walk visited %s=%v", p.name, key, value)
+			}
+			seen[key] = true
+			keys = append(keys, key)
+		})
+		if len(keys) != 9 || seen["5"] {
+			t.Errorf("%s: Walk visited %v; want all but 5", p.name, keys)
+		}
+		if p.name == "LRU" {
+			if got, want := fmt.Sprint(keys), "[0 1 3 4 6 7 8 9 2]"; got != want {
+				t.Errorf("LRU: Walk visited %v; want %v", got, want)
+			}
+		}
+	}
+}
+
+func mustAtoi(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func TestEvictionOrder(t *testing.T) {
+	tests := []struct {
+		policy Policy
+		name   string
+		want   string
+	}{
+		// After adding a..e and using b, c twice and d once:
+		{LRU, "LRU", "[a e b c d]"},
+		{LFU, "LFU", "[a e d b c]"},
+		{SLRU, "SLRU", "[a e b c d]"},
+	}
+	for _, tt := range tests {
+		var evicted []string
+		c := tt.policy(func(key string, value interface{}) {
+			evicted = append(evicted, key)
+		})
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			c.Add(key, nil)
+		}
+		for _, key := range []string{"b", "c", "b", "c", "d"} {
+			c.Get(key)
+		}
+		c.Peek("a") // not a use
+		for c.Len() > 0 {
+			c.RemoveOldest()
+		}
+		if got := fmt.Sprint(evicted); got != tt.want {
+			t.Errorf("%s evicted %s; want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// tests that W-TinyLFU's admission compares the entry just out of
+// the window with the probationary victim, even when an entry
+// demoted from the protected segment has since joined the
+// probationary segment's front.
+func TestTinyLFUCandidate(t *testing.T) {
+	var evicted []string
+	c := TinyLFU(func(key string, value interface{}) {
+		evicted = append(evicted, key)
+	}).(*tinyLFU)
+	use := func(key string, n int) {
+		for i := 0; i < n; i++ {
+			c.Get(key)
+		}
+	}
+	use("victim", 5)
+	c.Add("victim", nil)
+	for i := 0; i < 10; i++ {
+		key := "hot" + strconv.Itoa(i)
+		use(key, 5)
+		c.Add(key, nil)
+	}
+	for i := 0; i < 9; i++ {
+		use("hot"+strconv.Itoa(i), 1)
+	}
+	c.Add("new", nil)
+	c.Add("next", nil) // moves new out of the window
+	use("hot9", 1)
+	use("hot0", 1) // demotes a protected entry
+	if front := c.probation.Front().Value.(*entry).key; front == "new" {
+		t.Fatal("no entry demoted ahead of the newcomer")
+	}
+	if back := c.probation.Back().Value.(*entry).key; back != "victim" {
+		t.Fatalf("probationary victim is %s; want victim", back)
+	}
+	c.RemoveOldest()
+	if fmt.Sprint(evicted) != "[new]" {
+		t.Errorf("evicted %v; want the rarely used newcomer", evicted)
+	}
+}
+
+// tests that a scan of keys used only once doesn't flush out a
+// popular working set, except with LRU.
+func TestScanResistance(t *testing.T) {
+	const size = 200
+	for _, p := range policies {
+		c := p.policy(nil)
+		get := func(key string) {
+			if _, ok := c.Get(key); !ok {
+				c.Add(key, nil)
+				for c.Len() > size {
+					c.RemoveOldest()
+				}
+			}
+		}
+		for round := 0; round < 10; round++ {
+			for i := 0; i < size/2; i++ {
+				get("hot" + strconv.Itoa(i))
+			}
+		}
+		for i := 0; i < 10*size; i++ {
+			get("scan" + strconv.Itoa(i))
+		}
+		kept := 0
+		for i := 0; i < size/2; i++ {
+			if _, ok := c.Get("hot" + strconv.Itoa(i)); ok {
+				kept++
+			}
+		}
+		if p.name == "LRU" {
+			if kept != 0 {
+				t.Errorf("LRU kept %d popular keys; want 0", kept)
+			}
+		} else if kept < size/4 {
+			t.Errorf("%s kept %d of %d popular keys; want at least %d", p.name, kept, size/2, size/4)
+		}
+	}
+}
+
+// benchmarkZipf reports the hit ratio of each policy on a workload
+// whose key popularity follows a Zipf distribution with parameter s,
+// over a key space 100 times larger than the cache.
+func benchmarkZipf(b *testing.B, s float64) {
+	const size = 1000
+	for _, p := range policies {
+		b.Run(p.name, func(b *testing.B) {
+			r := rand.New(rand.NewSource(1))
+			z := rand.NewZipf(r, s, 1, 100*size-1)
+			c := p.policy(nil)
+			hits := 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				key := strconv.FormatUint(z.Uint64(), 10)
+				if _, ok := c.Get(key); ok {
+					hits++
+					continue
+				}
+				c.Add(key, nil)
+				for c.Len() > size {
+					c.RemoveOldest()
+				}
+			}
+			b.ReportMetric(100*float64(hits)/float64(b.N), "hit%")
+		})
+	}
+}
+
+func BenchmarkZipf101(b *testing.B) { benchmarkZipf(b, 1.01) }
+func BenchmarkZipf12(b *testing.B)  { benchmarkZipf(b, 1.2) }
@@ -0,0 +1,148 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package evict
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// LFU is a Policy that evicts the least frequently used entry,
+// breaking ties by evicting the least recently used. Counts never
+// decay, so LFU suits workloads whose popular keys don't change.
+func LFU(onEvicted func(key string, value interface{})) Cache {
+	return &lfu{
+		onEvicted: onEvicted,
+		items:     make(map[string]*lfuEntry),
+	}
+}
+
+type lfu struct {
+	onEvicted func(key string, value interface{})
+	items     map[string]*lfuEntry
+	heap      lfuHeap
+	tick      uint64
+}
+
+type lfuEntry struct {
+	key   string
+	value interface{}
+	freq  int
+	tick  uint64 // of the last access
+	index int    // in the heap
+}
+
+func (c *lfu) Add(key string, value interface{}) {
+	if e, ok := c.items[key]; ok {
+		e.value = value
+		c.touch(e)
+		return
+	}
+	c.tick++
+	e := &lfuEntry{key: key, value: value, freq: 1, tick: c.tick}
+	c.items[key] = e
+	heap.Push(&c.heap, e)
+}
+
+func (c *lfu) Get(key string) (value interface{}, ok bool) {
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.touch(e)
+	return e.value, true
+}
+
+func (c *lfu) Peek(key string) (value interface{}, ok bool) {
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *lfu) touch(e *lfuEntry) {
+	c.tick++
+	e.freq++
+	e.tick = c.tick
+	heap.Fix(&c.heap, e.index)
+}
+
+func (c *lfu) Remove(key string) {
+	if e, ok := c.items[key]; ok {
+		heap.Remove(&c.heap, e.index)
+		c.evicted(e)
+	}
+}
+
+func (c *lfu) RemoveOldest() {
+	if len(c.heap) > 0 {
+		c.evicted(heap.Pop(&c.heap).(*lfuEntry))
+	}
+}
+
+func (c *lfu) evicted(e *lfuEntry) {
+	delete(c.items, e.key)
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
+
+func (c *lfu) Len() int {
+	return len(c.items)
+}
+
+func (c *lfu) Walk(fn func(key string, value interface{})) {
+	h := append(lfuHeap(nil), c.heap...)
+	sort.Slice(h, h.Less)
+	for _, e := range h {
+		fn(e.key, e.value)
+	}
+}
+
+// lfuHeap implements heap.Interface, with the entry to evict first
+// at the root.
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].tick < h[j].tick
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
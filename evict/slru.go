@@ -0,0 +1,117 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package evict
+
+import "container/list"
+
+// SLRU is a Policy implementing a segmented LRU. New entries start
+// in a probationary segment and move to a protected segment, which
+// holds at most 80% of the entries, when they are used again.
+// Entries are evicted from the probationary segment first, so a scan
+// of keys used only once can't flush out the protected entries.
+func SLRU(onEvicted func(key string, value interface{})) Cache {
+	return &slru{
+		onEvicted: onEvicted,
+		items:     make(map[string]*list.Element),
+		probation: list.New(),
+		protected: list.New(),
+	}
+}
+
+type slru struct {
+	onEvicted func(key string, value interface{})
+	items     map[string]*list.Element
+	probation *list.List
+	protected *list.List
+}
+
+func (c *slru) Add(key string, value interface{}) {
+	if ele, ok := c.items[key]; ok {
+		ele.Value.(*entry).value = value
+		c.touch(ele)
+		return
+	}
+	c.items[key] = c.probation.PushFront(&entry{key, value, probation})
+}
+
+func (c *slru) Get(key string) (value interface{}, ok bool) {
+	ele, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.touch(ele)
+	return ele.Value.(*entry).value, true
+}
+
+// touch records a use of the entry in ele, promoting it to the
+// protected segment.
+func (c *slru) touch(ele *list.Element) {
+	e := ele.Value.(*entry)
+	if e.seg == protected {
+		c.protected.MoveToFront(ele)
+		return
+	}
+	c.probation.Remove(ele)
+	e.seg = protected
+	c.items[e.key] = c.protected.PushFront(e)
+	if c.protected.Len()*5 > c.Len()*4 {
+		// Demote the least recently used protected entry.
+		ele := c.protected.Back()
+		e := c.protected.Remove(ele).(*entry)
+		e.seg = probation
+		c.items[e.key] = c.probation.PushFront(e)
+	}
+}
+
+func (c *slru) Peek(key string) (value interface{}, ok bool) {
+	return peek(c.items, key)
+}
+
+func (c *slru) Remove(key string) {
+	if ele, ok := c.items[key]; ok {
+		c.removeElement(ele)
+	}
+}
+
+func (c *slru) RemoveOldest() {
+	if ele := c.probation.Back(); ele != nil {
+		c.removeElement(ele)
+	} else if ele := c.protected.Back(); ele != nil {
+		c.removeElement(ele)
+	}
+}
+
+func (c *slru) removeElement(ele *list.Element) {
+	e := ele.Value.(*entry)
+	if e.seg == protected {
+		c.protected.Remove(ele)
+	} else {
+		c.probation.Remove(ele)
+	}
+	delete(c.items, e.key)
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
+
+func (c *slru) Len() int {
+	return len(c.items)
+}
+
+func (c *slru) Walk(fn func(key string, value interface{})) {
+	walkLists(fn, c.probation, c.protected)
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package evict
+
+import (
+	"container/list"
+	"hash/fnv"
+)
+
+// TinyLFU is a Policy implementing W-TinyLFU, as used by Caffeine.
+// New entries enter a small LRU window. Entries leaving the window
+// join a segmented LRU (see SLRU) holding the rest of the cache,
+// whose probationary segment evicts either its least recently used
+// entry or its newest, whichever a sketch of recent key frequencies
+// estimates is used less. This keeps one-off keys from displacing
+// popular ones, while the window lets new keys build up a history.
+func TinyLFU(onEvicted func(key string, value interface{})) Cache {
+	return &tinyLFU{
+		onEvicted: onEvicted,
+		items:     make(map[string]*list.Element),
+		window:    list.New(),
+		probation: list.New(),
+		protected: list.New(),
+		sketch:    newSketch(minSketchWidth),
+	}
+}
+
+type tinyLFU struct {
+	onEvicted func(key string, value interface{})
+	items     map[string]*list.Element
+	window    *list.List
+	probation *list.List
+	protected *list.List
+	sketch    *sketch
+
+	// candidate is the entry last moved out of the window, which
+	// competes with the probationary segment's oldest to stay. It
+	// is stale once the entry is used, moved or removed.
+	candidate *list.Element
+}
+
+func (c *tinyLFU) list(seg segment) *list.List {
+	switch seg {
+	case window:
+		return c.window
+	case probation:
+		return c.probation
+	}
+	return c.protected
+}
+
+// move moves the entry in ele to the front of seg's list.
+func (c *tinyLFU) move(ele *list.Element, seg segment) {
+	e := ele.Value.(*entry)
+	c.list(e.seg).Remove(ele)
+	e.seg = seg
+	c.items[e.key] = c.list(seg).PushFront(e)
+}
+
+func (c *tinyLFU) Add(key string, value interface{}) {
+	if ele, ok := c.items[key]; ok {
+		ele.Value.(*entry).value = value
+		c.touch(ele)
+		return
+	}
+	// The key's use was counted by the Get that missed it.
+	c.items[key] = c.window.PushFront(&entry{key, value, window})
+	if len(c.items) > c.sketch.width() {
+		c.sketch = newSketch(2 * c.sketch.width())
+	}
+	// Keep the window to 1% of the entries.
+	for c.window.Len() > 1 && c.window.Len()*100 > len(c.items) {
+		e := c.window.Back().Value.(*entry)
+		c.move(c.window.Back(), probation)
+		c.candidate = c.items[e.key]
+	}
+}
+
+func (c *tinyLFU) Get(key string) (value interface{}, ok bool) {
+	ele, ok := c.items[key]
+	if !ok {
+		c.sketch.add(key)
+		return nil, false
+	}
+	c.touch(ele)
+	return ele.Value.(*entry).value, true
+}
+
+// touch records a use of the entry in ele.
+func (c *tinyLFU) touch(ele *list.Element) {
+	e := ele.Value.(*entry)
+	c.sketch.add(e.key)
+	switch e.seg {
+	case window, protected:
+		c.list(e.seg).MoveToFront(ele)
+		return
+	}
+	c.move(ele, protected)
+	// Keep the protected segment to 80% of the entries outside the
+	// window.
+	if c.protected.Len()*5 > (c.probation.Len()+c.protected.Len())*4 {
+		c.move(c.protected.Back(), probation)
+	}
+}
+
+func (c *tinyLFU) Peek(key string) (value interface{}, ok bool) {
+	return peek(c.items, key)
+}
+
+func (c *tinyLFU) Remove(key string) {
+	if ele, ok := c.items[key]; ok {
+		c.removeElement(ele)
+	}
+}
+
+func (c *tinyLFU) RemoveOldest() {
+	victim := c.probation.Back()
+	if victim == nil {
+		victim = c.protected.Back()
+	}
+	if victim == nil {
+		victim = c.window.Back()
+	}
+	if victim == nil {
+		return
+	}
+	// The entry just out of the window competes with the oldest to
+	// stay, once. Entries demoted from the protected segment also
+	// join the front of the probationary one, so the candidate is
+	// tracked rather than taken from there.
+	if candidate := c.candidate; candidate != nil && candidate != victim && c.items[candidate.Value.(*entry).key] == candidate {
+		if c.sketch.estimate(candidate.Value.(*entry).key) <= c.sketch.estimate(victim.Value.(*entry).key) {
+			victim = candidate
+		}
+	}
+	c.candidate = nil
+	c.removeElement(victim)
+}
+
+func (c *tinyLFU) removeElement(ele *list.Element) {
+	e := ele.Value.(*entry)
+	c.list(e.seg).Remove(ele)
+	delete(c.items, e.key)
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
+
+func (c *tinyLFU) Len() int {
+	return len(c.items)
+}
+
+func (c *tinyLFU) Walk(fn func(key string, value interface{})) {
+	walkLists(fn, c.probation, c.window, c.protected)
+}
+
+// minSketchWidth is the number of counters in each row of a new
+// sketch. The sketch widens as the cache grows.
+const minSketchWidth = 64
+
+// maxCount is the most a sketch counter counts to.
+const maxCount = 15
+
+// sketch is a count-min sketch estimating how often keys were used
+// recently. Its counts are halved periodically, so that keys no
+// longer used are forgotten.
+type sketch struct {
+	rows    [4][]uint8
+	mask    uint32
+	adds    int // since the counts were last halved
+	resetAt int
+}
+
+func newSketch(width int) *sketch {
+	s := &sketch{
+		mask:    uint32(width - 1),
+		resetAt: 10 * width,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *sketch) width() int {
+	return len(s.rows[0])
+}
+
+// index returns the counter for key in row i, given key's hash.
+func (s *sketch) index(h uint64, i int) uint32 {
+	lo, hi := uint32(h), uint32(h>>32)|1
+	return (lo + uint32(i)*hi) & s.mask
+}
+
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+func (s *sketch) add(key string) {
+	h := hash(key)
+	for i := range s.rows {
+		if c := &s.rows[i][s.index(h, i)]; *c < maxCount {
+			*c++
+		}
+	}
+	if s.adds++; s.adds >= s.resetAt {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] /= 2
+			}
+		}
+		s.adds /= 2
+	}
+}
+
+func (s *sketch) estimate(key string) uint8 {
+	h := hash(key)
+	n := uint8(maxCount)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(h, i)]; c < n {
+			n = c
+		}
+	}
+	return n
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	pb "github.com/golang/groupcache/groupcachepb"
+)
+
+// A MultiError is returned by GetMulti when loading one or more keys
+// fails. It holds an error, or nil, for each requested key.
+type MultiError []error
+
+func (m MultiError) Error() string {
+	var first error
+	n := 0
+	for _, err := range m {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			n++
+		}
+	}
+	switch n {
+	case 0:
+		return "(0 errors)"
+	case 1:
+		return first.Error()
+	}
+	return fmt.Sprintf("%v (and %d other errors)", first, n-1)
+}
+
+// GetMulti is like Get for several keys at once, populating dests[i]
+// with the value for keys[i].
+//
+// Keys owned by the same peer are fetched from it with a single
+// request, if the peer supports it, and keys owned by the current
+// process are loaded in parallel. Loads are deduplicated with
+// concurrent Get and GetMulti calls for the same keys.
+//
+// If loading any key fails, GetMulti returns a MultiError.
+func (g *Group) GetMulti(ctx Context, keys []string, dests []Sink) (err error) {
+	g.peersOnce.Do(g.initPeers)
+	if len(keys) != len(dests) {
+		return errors.New("groupcache: GetMulti keys and dests differ in length")
+	}
+	for _, dest := range dests {
+		if dest == nil {
+			return errors.New("groupcache: nil dest Sink")
+		}
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	g.Stats.Gets.Add(int64(len(keys)))
+	ctx, span := g.startSpan(ctx, "groupcache.GetMulti")
+	span.SetAttribute("keys", strconv.Itoa(len(keys)))
+	defer func() { span.End(err) }()
+
+	errs := make(MultiError, len(keys))
+	byPeer := make(map[ProtoGetter][]int) // indexes into keys
+	routes := make([]route, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		if value, ok := g.lookupCache(key); ok {
+			g.Stats.CacheHits.Add(1)
+			g.checkStale(key, value)
+			errs[i] = setSinkView(dests[i], value)
+			continue
+		}
+		if err := g.negCache.get(key); err != nil {
+			g.Stats.NegativeHits.Add(1)
+			errs[i] = err
+			continue
+		}
+		if peers, isOwner := g.pickPeers(key); len(peers) > 0 {
+			routes[i] = route{peers[1:], isOwner}
+			byPeer[peers[0]] = append(byPeer[peers[0]], i)
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = g.loadInto(ctx, keys[i], dests[i])
+		}(i)
+	}
+	for peer, idx := range byPeer {
+		wg.Add(1)
+		go func(peer ProtoGetter, idx []int) {
+			defer wg.Done()
+			g.loadMultiFromPeer(ctx, peer, keys, dests, routes, idx, errs)
+		}(peer, idx)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return errs
+		}
+	}
+	return nil
+}
+
+// route holds where to fetch a key from if its owner fails.
+type route struct {
+	fallbacks []ProtoGetter // the key's other owners, in order
+	isOwner   bool          // whether the current process is an owner
+}
+
+// loadMultiFromPeer loads keys[i] into dests[i], for each i in idx,
+// from their common owner peer, recording failures in errs[i]. Keys
+// the owner fails to return are fetched following routes[i].
+func (g *Group) loadMultiFromPeer(ctx Context, peer ProtoGetter, keys []string, dests []Sink, routes []route, idx []int, errs []error) {
+	batch := make([]string, len(idx))
+	keyRoutes := make(map[string]route, len(idx))
+	for j, i := range idx {
+		batch[j] = keys[i]
+		keyRoutes[keys[i]] = routes[i]
+	}
+	g.Stats.Loads.Add(int64(len(batch)))
+	for _, key := range batch {
+		g.ghosts.load(key)
+	}
+	start := time.Now()
+	vals, lerrs := g.loadGroup.DoMulti(ctx, batch, func(batch []string) ([]interface{}, []error) {
+		g.Stats.LoadsDeduped.Add(int64(len(batch)))
+		lctx, cancel := g.loadContext(ctx)
+		defer cancel()
+		values, perrs := g.getMultiFromPeer(lctx, peer, batch)
+		vals := make([]interface{}, len(batch))
+		errs := make([]error, len(batch))
+		var wg sync.WaitGroup
+		for i, key := range batch {
+			if perrs[i] == nil {
+				g.Stats.PeerLoads.Add(1)
+				vals[i] = values[i]
+				continue
+			}
+			wg.Add(1)
+			go func(i int, key string, r route) {
+				defer wg.Done()
+				var scratch ByteView
+				var value ByteView
+				var err error
+				if _, answered := perrs[i].(*remoteError); !answered && len(r.fallbacks) > 0 && lctx.Err() == nil {
+					g.peerFailed(key, perrs[i])
+					value, _, err = g.fetchFrom(lctx, key, ByteViewSink(&scratch), r.fallbacks, r.isOwner)
+				} else {
+					value, _, err = g.fallback(lctx, key, ByteViewSink(&scratch), perrs[i], r.isOwner)
+				}
+				if err != nil {
+					errs[i] = g.loadErr(ctx, lctx, err)
+					return
+				}
+				vals[i] = value
+			}(i, key, keyRoutes[key])
+		}
+		wg.Wait()
+		return vals, errs
+	})
+	g.Histograms.LoadWait.observeSince(start)
+	for j, i := range idx {
+		switch err := lerrs[j]; {
+		case err == nil:
+			errs[i] = setSinkView(dests[i], vals[j].(ByteView))
+		case isContextErr(err) && ctx.Err() == nil:
+			// We shared a load with a caller whose context was
+			// cancelled, but ours is still live.
+			errs[i] = g.loadInto(ctx, keys[i], dests[i])
+		default:
+			errs[i] = err
+		}
+	}
+}
+
+// getMultiFromPeer fetches keys from their owner, peer, returning a
+// value and an error for each key as getFromPeer would.
+func (g *Group) getMultiFromPeer(ctx Context, peer ProtoGetter, keys []string) ([]ByteView, []error) {
+	values := make([]ByteView, len(keys))
+	errs := make([]error, len(keys))
+	mg, ok := peer.(ProtoMultiGetter)
+	if !ok {
+		// Fall back to a request per key.
+		var wg sync.WaitGroup
+		for i, key := range keys {
+			wg.Add(1)
+			go func(i int, key string) {
+				defer wg.Done()
+				values[i], errs[i] = g.getFromPeer(ctx, peer, key)
+			}(i, key)
+		}
+		wg.Wait()
+		return values, errs
+	}
+
+	req := &pb.GetMultiRequest{
+		Group:          &g.name,
+		Key:            keys,
+		AcceptEncoding: g.acceptEncoding(),
+	}
+	res := &pb.GetMultiResponse{}
+	id := peerID(peer)
+	ctx, span := g.startSpan(ctx, "groupcache.peer_fetch")
+	span.SetAttribute("peer", id)
+	span.SetAttribute("keys", strconv.Itoa(len(keys)))
+	for _, key := range keys {
+		g.observer().PeerFetchStart(key, id)
+	}
+	start := time.Now()
+	err := mg.GetMulti(ctx, req, res)
+	if err == nil && len(res.Response) != len(keys) {
+		err = fmt.Errorf("groupcache: peer returned %d responses for %d keys", len(res.Response), len(keys))
+	}
+	d := time.Since(start)
+	g.Histograms.PeerFetch.Observe(int64(d))
+	span.End(err)
+	defer func() {
+		for i, key := range keys {
+			g.observer().PeerFetchDone(key, id, d, errs[i])
+		}
+	}()
+	for i, key := range keys {
+		switch {
+		case err != nil:
+			errs[i] = err
+		case i < len(res.Failed) && res.Failed[i]:
+			errs[i] = errors.New("groupcache: peer failed to load key")
+		default:
+			values[i], errs[i] = g.peerValue(key, res.Response[i])
+		}
+	}
+	return values, errs
+}
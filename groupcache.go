@@ -25,25 +25,43 @@ limitations under the License.
 package groupcache
 
 import (
+	"context"
 	"errors"
-	"math/rand"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"code.google.com/p/goprotobuf/proto"
+	"github.com/golang/groupcache/evict"
 	pb "github.com/golang/groupcache/groupcachepb"
 	"github.com/golang/groupcache/lru"
 	"github.com/golang/groupcache/singleflight"
 )
 
+// ErrNotFound may be returned, possibly wrapped, by a Getter to
+// report that no value exists for a key. Unlike other errors, it is
+// always reported to the requesting peer as an answer rather than as
+// a failure, so the peer does not retry the load itself.
+var ErrNotFound = errors.New("groupcache: not found")
+
+// ErrLoadTimeout is returned by Get when loading a key takes longer
+// than the group's LoadTimeout. Like errors from a cancelled Context,
+// it is never cached.
+var ErrLoadTimeout = errors.New("groupcache: load timed out")
+
 // A Getter loads data for a key.
 type Getter interface {
 	// Get returns the value identified by key, populating dest.
 	//
-	// The returned data must be unversioned. That is, key must
+	// The returned data should be unversioned. That is, key should
 	// uniquely describe the loaded data, without an implicit
-	// current time, and without relying on cache expiration
-	// mechanisms.
+	// current time. Data that does change over time may instead
+	// be given a lifetime with dest.SetExpiry, after which no
+	// peer serves it from cache.
+	//
+	// ctx is never nil. Implementations should return promptly
+	// with ctx.Err() once ctx is done.
 	Get(ctx Context, key string, dest Sink) error
 }
 
@@ -54,21 +72,10 @@ func (f GetterFunc) Get(ctx Context, key string, dest Sink) error {
 	return f(ctx, key, dest)
 }
 
-var (
-	mu     sync.RWMutex
-	groups = make(map[string]*Group)
-
-	initPeerServerOnce sync.Once
-	initPeerServer     func()
-)
-
 // GetGroup returns the named group previously created with NewGroup, or
 // nil if there's no such group.
 func GetGroup(name string) *Group {
-	mu.RLock()
-	g := groups[name]
-	mu.RUnlock()
-	return g
+	return DefaultRegistry.GetGroup(name)
 }
 
 // NewGroup creates a coordinated group-aware Getter from a Getter.
@@ -80,69 +87,55 @@ func GetGroup(name string) *Group {
 // completes.
 //
 // The group name must be unique for each getter.
+//
+// NewGroup registers the group with DefaultRegistry.
 func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
-	return newGroup(name, cacheBytes, getter, nil)
+	return DefaultRegistry.NewGroup(name, cacheBytes, getter)
+}
+
+// UnregisterGroup removes the named group from DefaultRegistry.
+func UnregisterGroup(name string) {
+	DefaultRegistry.UnregisterGroup(name)
+}
+
+// NewGroupWithOptions is like NewGroup, with the group configured
+// by opts.
+func NewGroupWithOptions(name string, cacheBytes int64, getter Getter, opts GroupOptions) *Group {
+	return DefaultRegistry.NewGroupWithOptions(name, cacheBytes, getter, opts)
 }
 
 // If peers is nil, the peerPicker is called via a sync.Once to initialize it.
 func newGroup(name string, cacheBytes int64, getter Getter, peers PeerPicker) *Group {
-	if getter == nil {
-		panic("nil Getter")
-	}
-	mu.Lock()
-	defer mu.Unlock()
-	initPeerServerOnce.Do(callInitPeerServer)
-	if _, dup := groups[name]; dup {
-		panic("duplicate registration of group " + name)
-	}
-	g := &Group{
-		name:       name,
-		getter:     getter,
-		peers:      peers,
-		cacheBytes: cacheBytes,
-	}
-	if fn := newGroupHook; fn != nil {
-		fn(g)
-	}
-	groups[name] = g
-	return g
+	return DefaultRegistry.newGroup(name, cacheBytes, getter, peers)
 }
 
-// newGroupHook, if non-nil, is called right after a new group is created.
-var newGroupHook func(*Group)
-
 // RegisterNewGroupHook registers a hook that is run each time
-// a group is created.
+// a group is created in DefaultRegistry.
 func RegisterNewGroupHook(fn func(*Group)) {
-	if newGroupHook != nil {
-		panic("RegisterNewGroupHook called more than once")
-	}
-	newGroupHook = fn
+	DefaultRegistry.RegisterNewGroupHook(fn)
 }
 
 // RegisterServerStart registers a hook that is run when the first
-// group is created.
+// group is created in DefaultRegistry.
 func RegisterServerStart(fn func()) {
-	if initPeerServer != nil {
-		panic("RegisterServerStart called more than once")
-	}
-	initPeerServer = fn
-}
-
-func callInitPeerServer() {
-	if initPeerServer != nil {
-		initPeerServer()
-	}
+	DefaultRegistry.RegisterServerStart(fn)
 }
 
 // A Group is a cache namespace and associated data loaded spread over
 // a group of 1 or more machines.
 type Group struct {
 	name       string
+	registry   *Registry
 	getter     Getter
 	peersOnce  sync.Once
 	peers      PeerPicker
-	cacheBytes int64 // limit for sum of mainCache and hotCache size
+	peersReady int32 // set to 1 once peers is initialized; accessed atomically
+	cacheBytes int64 // limit for sum of mainCache and hotCache size; accessed atomically
+
+	// GroupOptions configure the group. Its fields may be set
+	// individually after the group is created, but must be set
+	// before the group is first used.
+	GroupOptions
 
 	// mainCache is a cache of the keys for which this process
 	// (amongst its peers) is authorative. That is, this cache
@@ -160,13 +153,175 @@ type Group struct {
 	// of key/value pairs that can be stored globally.
 	hotCache cache
 
+	// negCache holds errors for keys whose loads failed, if
+	// NegativeTTL is positive.
+	negCache negativeCache
+
+	// errors holds the most recent failed peer requests and loads.
+	errLog errorLog
+
+	// keyRates tracks how often peers request each key, to be
+	// reported back to them in GetResponse.MinuteQps.
+	keyRates rateTracker
+
+	// ghosts remembers recent evictions while the group belongs to
+	// a Budget.
+	ghosts ghostList
+
 	// loadGroup ensures that each key is only fetched once
 	// (either locally or remotely), regardless of the number of
 	// concurrent callers.
 	loadGroup singleflight.Group
 
+	refreshMu  sync.Mutex
+	refreshing map[string]bool // keys with a refresh in progress
+
 	// Stats are statistics on the group.
 	Stats Stats
+
+	// Histograms are distributions of the group's latencies and
+	// value sizes.
+	Histograms Histograms
+}
+
+// GroupOptions configure a Group. The zero value is the
+// configuration of a group created by NewGroup.
+type GroupOptions struct {
+	// Peers, if non-nil, picks the peers that own the group's
+	// keys, in place of the PeerPicker registered with the group's
+	// Registry.
+	// It must be set before the group is first used.
+	Peers PeerPicker
+
+	// NegativeTTL, if positive, is how long an error returned by
+	// the Getter for a key is cached and returned to subsequent
+	// callers without consulting the Getter again. Errors returned
+	// by the key's owner are cached too, for as long as the owner
+	// says. Errors caused by a cancelled or expired Context are
+	// never cached.
+	// It must be set before the group is first used.
+	NegativeTTL time.Duration
+
+	// StaleWhileRevalidate, if positive, is how long after a
+	// cached value expires it may still be served. A Get that
+	// finds such a stale value returns it immediately and starts
+	// a background reload of the key, which replaces the value if
+	// it succeeds.
+	// It must be set before the group is first used.
+	StaleWhileRevalidate time.Duration
+
+	// HotCacheQPS, if positive, is the request rate, in queries
+	// per second, at or above which a value fetched from its owner
+	// is mirrored in the hot cache. The rate is as reported by the
+	// owner. If HotCacheQPS is zero, or the owner doesn't report
+	// rates, a random HotFillProbability of fetched values are
+	// mirrored.
+	// It is ignored if HotCachePolicy is set.
+	// It must be set before the group is first used.
+	HotCacheQPS float64
+
+	// HotFillProbability is the chance that a value fetched from
+	// an owner that doesn't report request rates is mirrored in
+	// the hot cache. If zero, it is 1/10.
+	// It is ignored if HotCachePolicy is set.
+	// It must be set before the group is first used.
+	HotFillProbability float64
+
+	// HotCacheRatio is the size the hot cache is kept to, relative
+	// to the main cache. If zero, it is 1/8.
+	// It is ignored if HotCachePolicy is set.
+	// It must be set before the group is first used.
+	HotCacheRatio float64
+
+	// HotCachePolicy decides which values fetched from peers are
+	// mirrored in the hot cache, and which cache to evict from.
+	// If nil, a DefaultHotCachePolicy configured by HotCacheQPS,
+	// HotFillProbability and HotCacheRatio is used.
+	// It must be set before the group is first used.
+	HotCachePolicy HotCachePolicy
+
+	// CacheShards, if greater than 1, is the number of shards the
+	// main and hot caches are each split into by key hash. Each
+	// shard is locked independently, so that concurrent cache hits
+	// on different shards don't contend. Eviction stays roughly
+	// global: the group evicts from whichever shard holds the most
+	// bytes.
+	// It must be set before the group is first used.
+	CacheShards int
+
+	// EvictionPolicy decides which entries the main and hot caches
+	// evict when the group is over its cache bytes.
+	// If nil, evict.LRU is used.
+	// It must be set before the group is first used.
+	EvictionPolicy evict.Policy
+
+	// Observer, if non-nil, is notified of cache hits, peer
+	// fetches, local loads and evictions in the group.
+	// It must be set before the group is first used.
+	Observer Observer
+
+	// Tracer, if non-nil, records spans for the group's cache
+	// lookups, loads, peer fetches and calls to its Getter.
+	// It must be set before the group is first used.
+	Tracer Tracer
+
+	// FetchPolicy controls how keys are fetched from the peers
+	// that own them.
+	// It must be set before the group is first used.
+	FetchPolicy FetchPolicy
+
+	// MaxValueBytes, if positive, is the size of the largest value
+	// the group caches. Larger values are still returned to
+	// callers, but are loaded afresh each time. With a Codec, the
+	// limit applies to values' encoded size.
+	// It must be set before the group is first used.
+	MaxValueBytes int64
+
+	// Codec, if non-nil, compresses the values the group caches,
+	// and is offered to peers to send values in.
+	// It must be set before the group is first used.
+	Codec Codec
+
+	// DiskCache, if non-nil, is a second tier holding the entries
+	// the main cache evicts for lack of room. Gets that miss the
+	// main and hot caches look there before asking peers or the
+	// Getter, and move the entries they find back to the main
+	// cache.
+	// It must be set before the group is first used.
+	DiskCache *DiskCache
+
+	// SnapshotHotCache is whether Snapshot includes the hot cache
+	// as well as the main cache.
+	// It must be set before the group is first used.
+	SnapshotHotCache bool
+
+	// LoadTimeout, if positive, limits how long a load of a key,
+	// from its owners or the Getter, may take. A load that runs
+	// out of time fails with ErrLoadTimeout.
+	// It must be set before the group is first used.
+	LoadTimeout time.Duration
+}
+
+// FetchPolicy controls how a Group fetches keys it doesn't own. The
+// zero value asks only the key's owner and, if it can't be reached,
+// loads the key locally.
+type FetchPolicy struct {
+	// Owners is how many of a key's owners to try, in order, before
+	// giving up on its peers. Values below 1 mean 1. Owners after
+	// the first are known only if the group's PeerPicker implements
+	// MultiPeerPicker.
+	Owners int
+
+	// HedgeAfter, if positive, is how long to wait for an owner to
+	// answer before also asking the next one. The first answer
+	// wins. Get hedges its requests; GetMulti doesn't.
+	HedgeAfter time.Duration
+
+	// FailFast makes a load fail, rather than call the Getter, if
+	// none of the owners tried could be reached. It has no effect
+	// on keys for which the current process is itself one of the
+	// owners tried.
+	FailFast bool
 }
 
 // Stats are per-group statistics.
@@ -180,6 +335,8 @@ type Stats struct {
 	LocalLoads     AtomicInt // total good local loads
 	LocalLoadErrs  AtomicInt // total bad local loads
 	ServerRequests AtomicInt // gets that came over the network from peers
+	NegativeHits   AtomicInt // gets answered with a cached error
+	StaleHits      AtomicInt // cache hits on expired values being refreshed
 }
 
 // Name returns the name of the group.
@@ -188,29 +345,64 @@ func (g *Group) Name() string {
 }
 
 func (g *Group) initPeers() {
+	g.peers = g.Peers
 	if g.peers == nil {
-		g.peers = getPeers()
+		g.peers = g.registry.getPeers()
 	}
+	atomic.StoreInt32(&g.peersReady, 1)
 }
 
-func (g *Group) Get(ctx Context, key string, dest Sink) error {
+// initializedPeers returns g's peers, or nil if no request has
+// initialized them yet. Unlike peersOnce, it never fixes the group's
+// peers, so it's safe to call before the process registers them.
+func (g *Group) initializedPeers() PeerPicker {
+	if atomic.LoadInt32(&g.peersReady) == 0 {
+		return nil
+	}
+	return g.peers
+}
+
+// Get populates dest with the value identified by key, consulting the
+// local caches, then the key's owning peer, and finally the Getter.
+//
+// If ctx is cancelled or its deadline passes, Get abandons any peer
+// request or wait on another caller's load and returns ctx.Err().
+func (g *Group) Get(ctx Context, key string, dest Sink) (err error) {
 	g.peersOnce.Do(g.initPeers)
 	g.Stats.Gets.Add(1)
 	if dest == nil {
 		return errors.New("groupcache: nil dest Sink")
 	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := g.startKeySpan(ctx, "groupcache.Get", key)
+	defer func() { span.End(err) }()
+
+	_, lookupSpan := g.startKeySpan(ctx, "groupcache.lookup", key)
 	value, cacheHit := g.lookupCache(key)
+	lookupSpan.SetAttribute("hit", strconv.FormatBool(cacheHit))
+	lookupSpan.End(nil)
 
 	if cacheHit {
 		g.Stats.CacheHits.Add(1)
+		g.checkStale(key, value)
 		return setSinkView(dest, value)
 	}
+	if err := g.negCache.get(key); err != nil {
+		g.Stats.NegativeHits.Add(1)
+		return err
+	}
+
+	return g.loadInto(ctx, key, dest)
+}
 
+// loadInto loads key and populates dest with the result.
+func (g *Group) loadInto(ctx Context, key string, dest Sink) error {
 	// Optimization to avoid double unmarshalling or copying: keep
 	// track of whether the dest was already populated. One caller
 	// (if local) will set this; the losers will not. The common
 	// case will likely be one caller.
-	destPopulated := false
 	value, destPopulated, err := g.load(ctx, key, dest)
 	if err != nil {
 		return err
@@ -224,100 +416,668 @@ func (g *Group) Get(ctx Context, key string, dest Sink) error {
 // load loads key either by invoking the getter locally or by sending it to another machine.
 func (g *Group) load(ctx Context, key string, dest Sink) (value ByteView, destPopulated bool, err error) {
 	g.Stats.Loads.Add(1)
-	viewi, err := g.loadGroup.Do(key, func() (interface{}, error) {
-		g.Stats.LoadsDeduped.Add(1)
-		var value ByteView
-		var err error
-		if peer, ok := g.peers.PickPeer(key); ok {
-			value, err = g.getFromPeer(ctx, peer, key)
-			if err == nil {
-				g.Stats.PeerLoads.Add(1)
-				return value, nil
+	g.ghosts.load(key)
+	ctx, span := g.startKeySpan(ctx, "groupcache.singleflight", key)
+	defer func() { span.End(err) }()
+	defer g.Histograms.LoadWait.observeSince(time.Now())
+	var viewi interface{}
+	for {
+		leader := false
+		viewi, err = g.loadGroup.DoContext(ctx, key, func() (interface{}, error) {
+			leader = true
+			g.Stats.LoadsDeduped.Add(1)
+			value, local, err := g.fetch(ctx, key, dest)
+			if err != nil {
+				return nil, err
 			}
-			g.Stats.PeerErrors.Add(1)
-			// TODO(bradfitz): log the peer's error? keep
-			// log of the past few for /groupcachez?  It's
-			// probably boring (normal task movement), so not
-			// worth logging I imagine.
-		}
-		value, err = g.getLocally(ctx, key, dest)
-		if err != nil {
-			g.Stats.LocalLoadErrs.Add(1)
-			return nil, err
+			destPopulated = local // only one caller of load gets this return value
+			return value, nil
+		})
+		if !leader && isContextErr(err) && ctx.Err() == nil {
+			// We shared a load with a caller whose context
+			// was cancelled, but ours is still live. Try again.
+			continue
 		}
-		g.Stats.LocalLoads.Add(1)
-		destPopulated = true // only one caller of load gets this return value
-		g.populateCache(key, value, &g.mainCache)
-		return value, nil
-	})
+		span.SetAttribute("leader", strconv.FormatBool(leader))
+		break
+	}
 	if err == nil {
 		value = viewi.(ByteView)
 	}
 	return
 }
 
+// fetch gets key from its owners or, failing that, loads it locally
+// into dest. local reports whether the value was loaded locally.
+func (g *Group) fetch(ctx Context, key string, dest Sink) (value ByteView, local bool, err error) {
+	lctx, cancel := g.loadContext(ctx)
+	defer cancel()
+	peers, isOwner := g.pickPeers(key)
+	value, local, err = g.fetchFrom(lctx, key, dest, peers, isOwner)
+	return value, local, g.loadErr(ctx, lctx, err)
+}
+
+// loadContext returns the context for a load under ctx, bounded by
+// the group's LoadTimeout.
+func (g *Group) loadContext(ctx Context) (Context, context.CancelFunc) {
+	if g.LoadTimeout > 0 {
+		return context.WithTimeout(ctx, g.LoadTimeout)
+	}
+	return ctx, func() {}
+}
+
+// loadErr returns the error of a load run under lctx, derived by
+// loadContext from ctx, reporting ErrLoadTimeout if lctx expired
+// while ctx is still live.
+func (g *Group) loadErr(ctx, lctx Context, err error) error {
+	if err != nil && lctx.Err() != nil && ctx.Err() == nil {
+		return ErrLoadTimeout
+	}
+	return err
+}
+
+// pickPeers returns the peers to fetch key from, in order, as
+// chosen by FetchPolicy. isOwner reports whether the current process
+// is itself one of the key's owners.
+func (g *Group) pickPeers(key string) (peers []ProtoGetter, isOwner bool) {
+	n := g.FetchPolicy.Owners
+	if n < 1 {
+		n = 1
+	}
+	if mp, ok := g.peers.(MultiPeerPicker); ok {
+		return mp.PickPeers(key, n)
+	}
+	if peer, ok := g.peers.PickPeer(key); ok {
+		return []ProtoGetter{peer}, false
+	}
+	return nil, true
+}
+
+// fetchFrom is like fetch, but gets key from the given peers.
+func (g *Group) fetchFrom(ctx Context, key string, dest Sink, peers []ProtoGetter, isOwner bool) (value ByteView, local bool, err error) {
+	if len(peers) > 0 {
+		value, err = g.getFromPeers(ctx, peers, key)
+		if err == nil {
+			g.Stats.PeerLoads.Add(1)
+			return value, false, nil
+		}
+	}
+	return g.fallback(ctx, key, dest, err, isOwner)
+}
+
+// fallback loads key locally into dest after its peers failed with
+// err, unless the failure should be returned to the caller instead.
+// A nil err means there were no peers to ask.
+func (g *Group) fallback(ctx Context, key string, dest Sink, err error, isOwner bool) (ByteView, bool, error) {
+	if err != nil {
+		if perr := g.peerError(ctx, key, err); perr != nil {
+			return ByteView{}, false, perr
+		}
+		if g.FetchPolicy.FailFast && !isOwner {
+			return ByteView{}, false, err
+		}
+	}
+	value, err := g.loadLocally(ctx, key, dest)
+	return value, err == nil, err
+}
+
+// refresh reloads key in the background to replace a stale cached
+// value. At most one refresh of a key runs at a time, and it shares
+// its load with any concurrent Gets. If the reload fails, the stale
+// value stays in place.
+func (g *Group) refresh(key string) {
+	g.refreshMu.Lock()
+	if g.refreshing[key] {
+		g.refreshMu.Unlock()
+		return
+	}
+	if g.refreshing == nil {
+		g.refreshing = make(map[string]bool)
+	}
+	g.refreshing[key] = true
+	g.refreshMu.Unlock()
+
+	go func() {
+		defer func() {
+			g.refreshMu.Lock()
+			delete(g.refreshing, key)
+			g.refreshMu.Unlock()
+		}()
+		g.loadGroup.Do(key, func() (interface{}, error) {
+			g.Stats.LoadsDeduped.Add(1)
+			var scratch ByteView
+			value, local, err := g.fetch(context.Background(), key, ByteViewSink(&scratch))
+			if err != nil {
+				return nil, err
+			}
+			if !local {
+				// Replace the stale copy mirrored from the owner.
+				g.populateCache(key, value, &g.hotCache)
+			}
+			return value, nil
+		})
+	}()
+}
+
+// peerError accounts for err, returned when fetching key from its
+// owner. It returns nil if the caller should fall back to loading
+// key locally, or otherwise the error to return to the caller.
+func (g *Group) peerError(ctx Context, key string, err error) error {
+	if re, ok := err.(*remoteError); ok {
+		// The owner answered; its Getter failed.
+		g.Stats.PeerLoads.Add(1)
+		g.cacheError(key, re.err, re.expire)
+		return re.err
+	}
+	g.peerFailed(key, err)
+	if ctx.Err() != nil {
+		// The caller gave up; don't fall back to a local load on
+		// its behalf.
+		return ctx.Err()
+	}
+	return nil
+}
+
+// peerFailed accounts for err, a failure to get key from a peer.
+func (g *Group) peerFailed(key string, err error) {
+	g.Stats.PeerErrors.Add(1)
+	g.errLog.add("peer", key, err)
+}
+
+// loadLocally loads key with the Getter, populating dest, and adds
+// the result to the main cache.
+func (g *Group) loadLocally(ctx Context, key string, dest Sink) (ByteView, error) {
+	value, err := g.getLocally(ctx, key, dest)
+	if err != nil {
+		g.Stats.LocalLoadErrs.Add(1)
+		g.errLog.add("load", key, err)
+		g.cacheError(key, err, time.Time{})
+		return ByteView{}, err
+	}
+	g.Stats.LocalLoads.Add(1)
+	g.populateCache(key, value, &g.mainCache)
+	return value, nil
+}
+
+// isContextErr reports whether err is the result of a cancelled or
+// expired context.
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// isTransientErr reports whether err says nothing lasting about a
+// key, and so must not be cached or reported to peers.
+func isTransientErr(err error) bool {
+	return isContextErr(err) || errors.Is(err, ErrLoadTimeout)
+}
+
 func (g *Group) getLocally(ctx Context, key string, dest Sink) (ByteView, error) {
+	ctx, span := g.startKeySpan(ctx, "groupcache.local_load", key)
+	start := time.Now()
 	err := g.getter.Get(ctx, key, dest)
+	d := time.Since(start)
+	g.Histograms.LocalLoad.Observe(int64(d))
+	g.observer().LocalLoad(key, d, err)
+	span.End(err)
 	if err != nil {
 		return ByteView{}, err
 	}
-	return dest.view()
+	value, err := dest.view()
+	if err == nil {
+		g.Histograms.ValueSize.Observe(int64(value.Len()))
+	}
+	return value, err
 }
 
 func (g *Group) getFromPeer(ctx Context, peer ProtoGetter, key string) (ByteView, error) {
 	req := &pb.GetRequest{
-		Group: &g.name,
-		Key:   &key,
+		Group:          &g.name,
+		Key:            &key,
+		AcceptEncoding: g.acceptEncoding(),
 	}
 	res := &pb.GetResponse{}
+	id := peerID(peer)
+	ctx, span := g.startKeySpan(ctx, "groupcache.peer_fetch", key)
+	span.SetAttribute("peer", id)
+	g.observer().PeerFetchStart(key, id)
+	start := time.Now()
 	err := peer.Get(ctx, req, res)
+	var value ByteView
+	if err == nil {
+		value, err = g.peerValue(key, res)
+	}
+	d := time.Since(start)
+	g.Histograms.PeerFetch.Observe(int64(d))
+	g.observer().PeerFetchDone(key, id, d, err)
+	span.End(err)
+	return value, err
+}
+
+// getFromPeers gets key from the first of peers to answer. Each peer
+// is asked in turn when the previous one fails or, if
+// FetchPolicy.HedgeAfter is positive, is slow to answer. An answer
+// reporting that the Getter failed is returned as soon as it
+// arrives. Failures to reach any but the last peer to fail are
+// counted in PeerErrors; that last failure is returned.
+func (g *Group) getFromPeers(ctx Context, peers []ProtoGetter, key string) (ByteView, error) {
+	if len(peers) == 1 {
+		return g.getFromPeer(ctx, peers[0], key)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // abandon any requests still outstanding
+
+	type result struct {
+		value ByteView
+		err   error
+	}
+	results := make(chan result, len(peers))
+	next, pending := 0, 0
+	askNext := func() {
+		peer := peers[next]
+		next++
+		pending++
+		go func() {
+			value, err := g.getFromPeer(ctx, peer, key)
+			results <- result{value, err}
+		}()
+	}
+
+	askNext()
+	for {
+		var hedge <-chan time.Time
+		if g.FetchPolicy.HedgeAfter > 0 && next < len(peers) {
+			hedge = time.After(g.FetchPolicy.HedgeAfter)
+		}
+		select {
+		case <-hedge:
+			askNext()
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				return res.value, nil
+			}
+			if _, ok := res.err.(*remoteError); ok {
+				return ByteView{}, res.err
+			}
+			if next < len(peers) && ctx.Err() == nil {
+				g.peerFailed(key, res.err)
+				askNext()
+			} else if pending > 0 {
+				g.peerFailed(key, res.err)
+			} else {
+				return ByteView{}, res.err
+			}
+		}
+	}
+}
+
+// peerValue decodes res, the owner's response for key. A failure of
+// the owner's Getter is returned as a *remoteError. A value the owner
+// sent encoded is mirrored in the hot cache as it came, but returned
+// decoded.
+func (g *Group) peerValue(key string, res *pb.GetResponse) (ByteView, error) {
+	value := ByteView{b: res.Value}
+	if res.Expire != nil {
+		value.e = time.Unix(0, res.GetExpire())
+	}
+	if res.GetNotFound() {
+		return ByteView{}, &remoteError{ErrNotFound, value.e}
+	}
+	if res.Error != nil {
+		return ByteView{}, &remoteError{errors.New(res.GetError()), value.e}
+	}
+	z, err := g.peerEncoding(res.GetEncoding())
 	if err != nil {
 		return ByteView{}, err
 	}
-	value := ByteView{b: res.Value}
-	// TODO(bradfitz): use res.MinuteQps or something smart to
-	// conditionally populate hotCache.  For now just do it some
-	// percentage of the time.
-	if rand.Intn(10) == 0 {
-		g.populateCache(key, value, &g.hotCache)
+	encoded := value
+	encoded.z = z
+	if value, err = encoded.decode(); err != nil {
+		return ByteView{}, err
+	}
+	qps := -1.0
+	if res.MinuteQps != nil {
+		qps = res.GetMinuteQps()
+	}
+	g.Histograms.ValueSize.Observe(int64(value.Len()))
+	if g.hotCachePolicy().Admit(key, value, qps) {
+		g.populateCache(key, encoded, &g.hotCache)
 	}
 	return value, nil
 }
 
+func (g *Group) hotCachePolicy() HotCachePolicy {
+	if g.HotCachePolicy != nil {
+		return g.HotCachePolicy
+	}
+	return DefaultHotCachePolicy{
+		QPS:             g.HotCacheQPS,
+		FillProbability: g.HotFillProbability,
+		HotRatio:        g.HotCacheRatio,
+	}
+}
+
+// remoteError is an error returned by a peer's Getter, as opposed
+// to a failure to reach the peer.
+type remoteError struct {
+	err    error
+	expire time.Time // zero if the peer doesn't cache the error
+}
+
+func (e *remoteError) Error() string {
+	return e.err.Error()
+}
+
+// errorResponse returns the response that reports err, returned by
+// Get on behalf of a peer, to that peer. It returns nil if err
+// should instead be reported as a failed request, in which case the
+// peer is free to load the key itself.
+func (g *Group) errorResponse(err error) *pb.GetResponse {
+	res := &pb.GetResponse{}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		res.NotFound = proto.Bool(true)
+	case g.NegativeTTL > 0 && !isTransientErr(err):
+		res.Error = proto.String(err.Error())
+	default:
+		return nil
+	}
+	if g.NegativeTTL > 0 {
+		res.Expire = proto.Int64(time.Now().Add(g.NegativeTTL).UnixNano())
+	}
+	return res
+}
+
+// cacheError records err as the result of loading key, if negative
+// caching is enabled. If expire is zero, the error expires after
+// NegativeTTL.
+func (g *Group) cacheError(key string, err error, expire time.Time) {
+	if g.NegativeTTL <= 0 || isTransientErr(err) {
+		return
+	}
+	if expire.IsZero() {
+		expire = time.Now().Add(g.NegativeTTL)
+	}
+	g.negCache.add(key, err, expire)
+}
+
+// Remove removes key from the group's caches across all peers.
+//
+// The key is evicted locally and the removal is forwarded to the
+// key's owner, which evicts it from its own caches and then
+// invalidates any copies mirrored in other peers' hot caches. The
+// removal goes to the owner even if its circuit is open, and fails if
+// it can't be reached. A
+// load already in flight for key may still repopulate the caches
+// with the value it loaded.
+func (g *Group) Remove(ctx Context, key string) error {
+	g.peersOnce.Do(g.initPeers)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	g.localRemove(key)
+	peer, ok := g.pickOwner(key)
+	if !ok {
+		return g.removeFromPeers(ctx, key)
+	}
+	r, ok := peer.(ProtoRemover)
+	if !ok {
+		return errors.New("groupcache: key owner does not support Remove")
+	}
+	req := &pb.RemoveRequest{
+		Group: &g.name,
+		Key:   &key,
+	}
+	return r.Remove(ctx, req, &pb.RemoveResponse{})
+}
+
+// errNotOwner is returned for a removal sent to a peer that doesn't
+// own the key, as when peers' views of the ring differ.
+var errNotOwner = errors.New("groupcache: removal sent to a peer that doesn't own the key")
+
+// removeForPeer handles a removal sent by another peer. The key is
+// evicted locally and, if this process owns the key, the removal is
+// fanned out to every other peer. fromOwner reports whether the
+// removal is itself such a fan-out. Removals are never forwarded, so
+// peers with differing views of the ring can't bounce them around;
+// a removal sent to the wrong peer fails instead.
+func (g *Group) removeForPeer(ctx Context, key string, fromOwner bool) error {
+	g.peersOnce.Do(g.initPeers)
+	g.localRemove(key)
+	if fromOwner {
+		return nil
+	}
+	if _, ok := g.pickOwner(key); ok {
+		return errNotOwner
+	}
+	return g.removeFromPeers(ctx, key)
+}
+
+// pickOwner returns key's owner, as PickPeer does but, if the
+// PeerPicker is an OwnerPicker, without regard to its health.
+func (g *Group) pickOwner(key string) (ProtoGetter, bool) {
+	if op, ok := g.peers.(OwnerPicker); ok {
+		return op.PickOwner(key)
+	}
+	return g.peers.PickPeer(key)
+}
+
+// removeFromPeers sends a removal of key to all peers, if the
+// PeerPicker can list them. It returns the first error encountered.
+func (g *Group) removeFromPeers(ctx Context, key string) error {
+	lister, ok := g.peers.(PeerLister)
+	if !ok {
+		return nil
+	}
+	req := &pb.RemoveRequest{
+		Group:     &g.name,
+		Key:       &key,
+		FromOwner: proto.Bool(true),
+	}
+	peers := lister.ListPeers()
+	errc := make(chan error, len(peers))
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		r, ok := peer.(ProtoRemover)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(r ProtoRemover) {
+			defer wg.Done()
+			if err := r.Remove(ctx, req, &pb.RemoveResponse{}); err != nil {
+				errc <- err
+			}
+		}(r)
+	}
+	wg.Wait()
+	close(errc)
+	return <-errc
+}
+
+// Set stores value for key in the cache of the key's owner, so that
+// subsequent Gets don't need to load it. The value expires at
+// expire, unless expire is the zero Time. The caller retains
+// ownership of value. A value that can't be cached, being over
+// MaxValueBytes or already expired, still evicts the key's old value.
+//
+// Copies of key mirrored in other peers' hot caches are invalidated.
+// If hotCache is true and the current process is not the owner, the
+// value is also stored in the local hot cache.
+func (g *Group) Set(ctx Context, key string, value []byte, expire time.Time, hotCache bool) error {
+	g.peersOnce.Do(g.initPeers)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	view := ByteView{b: cloneBytes(value), e: expire}
+	g.negCache.remove(key)
+	peer, ok := g.pickOwner(key)
+	if !ok {
+		g.populateCache(key, view, &g.mainCache)
+		return g.removeFromPeers(ctx, key)
+	}
+	g.hotCache.remove(key)
+	s, ok := peer.(ProtoSetter)
+	if !ok {
+		return errors.New("groupcache: key owner does not support Set")
+	}
+	req := &pb.SetRequest{
+		Group: &g.name,
+		Key:   &key,
+		Value: view.b,
+	}
+	if !expire.IsZero() {
+		req.Expire = proto.Int64(expire.UnixNano())
+	}
+	if err := s.Set(ctx, req, &pb.SetResponse{}); err != nil {
+		return err
+	}
+	if hotCache {
+		g.populateCache(key, view, &g.hotCache)
+	}
+	return nil
+}
+
+// setForPeer handles a value pushed by another peer with Set. The
+// value is stored in the main cache and, as the owner, the
+// current process invalidates copies held by other peers.
+func (g *Group) setForPeer(ctx Context, key string, value ByteView) error {
+	g.peersOnce.Do(g.initPeers)
+	g.hotCache.remove(key)
+	g.negCache.remove(key)
+	g.populateCache(key, value, &g.mainCache)
+	return g.removeFromPeers(ctx, key)
+}
+
+func (g *Group) localRemove(key string) {
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	if g.DiskCache != nil {
+		g.DiskCache.remove(key)
+	}
+	g.negCache.remove(key)
+	g.ghosts.remove(key)
+}
+
 func (g *Group) lookupCache(key string) (value ByteView, ok bool) {
-	if g.cacheBytes <= 0 {
+	if g.CacheBytes() <= 0 {
 		return
 	}
-	value, ok = g.mainCache.get(key)
+	value, ok = g.mainCache.get(key, g.StaleWhileRevalidate)
 	if ok {
+		g.observer().CacheHit(key, MainCache)
 		return
 	}
-	value, ok = g.hotCache.get(key)
+	value, ok = g.hotCache.get(key, g.StaleWhileRevalidate)
+	if ok {
+		g.observer().CacheHit(key, HotCache)
+		return
+	}
+	if g.DiskCache == nil {
+		return
+	}
+	value, ok = g.DiskCache.get(key, g.StaleWhileRevalidate)
+	if ok {
+		g.observer().CacheHit(key, DiskTier)
+		// Move the entry back to the main cache, even if it is
+		// stale: it is served until a refresh replaces it. It
+		// stays on disk unless the main cache keeps it.
+		g.addToCache(key, value, &g.mainCache)
+		if g.mainCache.has(key) {
+			g.DiskCache.remove(key)
+		}
+	}
 	return
 }
 
+// checkStale starts a refresh of key if value, found in the cache,
+// has expired.
+func (g *Group) checkStale(key string, value ByteView) {
+	if value.expired(time.Now()) {
+		g.Stats.StaleHits.Add(1)
+		g.refresh(key)
+	}
+}
+
+// populateCache adds a new value to cache as key's, and removes any
+// older value of key from the disk tier, so that it isn't served in
+// the new value's place. A value that can't be cached, because
+// caching is off or the value is expired or too big, instead removes
+// the older value from cache too.
 func (g *Group) populateCache(key string, value ByteView, cache *cache) {
-	if g.cacheBytes <= 0 {
-		return
+	if g.DiskCache != nil {
+		g.DiskCache.remove(key)
 	}
-	cache.add(key, value)
+	if g.CacheBytes() <= 0 || value.expired(time.Now()) || !g.addToCache(key, value, cache) {
+		cache.remove(key)
+	}
+}
 
-	// Evict items from cache(s) if necessary.
+// addToCache adds value to cache as key's, even if it has expired,
+// unless it is over MaxValueBytes. It reports whether it did.
+func (g *Group) addToCache(key string, value ByteView, cache *cache) bool {
+	value = g.encode(value)
+	if g.MaxValueBytes > 0 && int64(value.Len()) > g.MaxValueBytes {
+		return false
+	}
+	which := MainCache
+	if cache == &g.hotCache {
+		which = HotCache
+	}
+	cache.add(key, value, cacheOptions{
+		shards: g.CacheShards,
+		policy: g.EvictionPolicy,
+		evicted: func(key string, reason EvictReason) {
+			g.observer().Evicted(key, which, reason)
+		},
+	})
+
+	g.evictToFit()
+	return true
+}
+
+// CacheBytes returns the limit on the total size of the group's
+// caches.
+func (g *Group) CacheBytes() int64 {
+	return atomic.LoadInt64(&g.cacheBytes)
+}
+
+// SetCacheBytes changes the limit on the total size of the group's
+// caches, evicting entries at once if they no longer fit. A limit of
+// zero or less disables caching.
+func (g *Group) SetCacheBytes(n int64) {
+	atomic.StoreInt64(&g.cacheBytes, n)
+	g.evictToFit()
+}
+
+// evictToFit evicts items from the caches until they fit in the
+// group's cache bytes.
+func (g *Group) evictToFit() {
 	for {
 		mainBytes := g.mainCache.bytes()
 		hotBytes := g.hotCache.bytes()
-		if mainBytes+hotBytes <= g.cacheBytes {
+		if mainBytes+hotBytes <= g.CacheBytes() {
 			return
 		}
 
-		// TODO(bradfitz): this is good-enough-for-now logic.
-		// It should be something based on measurements and/or
-		// respecting the costs of different resources.
-		victim := &g.mainCache
-		if hotBytes > mainBytes/8 {
-			victim = &g.hotCache
+		victim, other := &g.mainCache, &g.hotCache
+		if g.hotCachePolicy().Victim(mainBytes, hotBytes) == HotCache {
+			victim, other = other, victim
+		}
+		if victim.items() == 0 {
+			if other.items() == 0 {
+				return
+			}
+			victim = other
+		}
+		e := victim.removeOldest()
+		if e == nil {
+			continue
+		}
+		g.ghosts.add(e.key, int64(len(e.key)+e.value.Len()))
+		if victim == &g.mainCache && g.DiskCache != nil {
+			g.DiskCache.add(e.key, e.value)
 		}
-		victim.removeOldest()
 	}
 }
 
@@ -333,6 +1093,9 @@ const (
 	// enough to replicate to this node, even though it's not the
 	// owner.
 	HotCache
+
+	// The DiskTier is the group's DiskCache, if it has one.
+	DiskTier
 )
 
 // CacheStats returns stats about the provided cache within the group.
@@ -342,90 +1105,342 @@ func (g *Group) CacheStats(which CacheType) CacheStats {
 		return g.mainCache.stats()
 	case HotCache:
 		return g.hotCache.stats()
-	default:
-		return CacheStats{}
+	case DiskTier:
+		if g.DiskCache != nil {
+			return g.DiskCache.stats()
+		}
 	}
+	return CacheStats{}
 }
 
-// cache is a wrapper around an *lru.Cache that adds synchronization,
-// makes values always be ByteView, and counts the size of all keys and
-// values.
+// cache is a wrapper around a set of evict.Caches, its shards, that
+// adds synchronization, makes values always be ByteView, and counts
+// the size of all keys and values. Each key is held by the shard it
+// hashes to, and each shard is locked independently.
 type cache struct {
+	initMu sync.Mutex
+	shards atomic.Value // []*cacheShard, set by the first add
+	nbytes int64        // of all shards; accessed atomically
+}
+
+type cacheShard struct {
 	mu         sync.RWMutex
 	nbytes     int64 // of all keys and values
-	lru        *lru.Cache
+	entries    evict.Cache
 	nhit, nget int64
-	nevict     int64 // number of evictions
+	nevict     int64       // number of evictions
+	reason     EvictReason // of evictions by the operation in progress
+
+	victim *cacheEntry // the entry evicted by removeOldest
+}
+
+type cacheEntry struct {
+	key   string
+	value ByteView
+}
+
+// cacheOptions configure a cache when it is first added to.
+type cacheOptions struct {
+	shards  int          // number of shards; values below 1 mean 1
+	policy  evict.Policy // nil means evict.LRU
+	evicted func(key string, reason EvictReason)
+}
+
+func (c *cache) loadShards() []*cacheShard {
+	shards, _ := c.shards.Load().([]*cacheShard)
+	return shards
+}
+
+// shard returns the shard holding key, or nil if the cache has
+// never been added to.
+func (c *cache) shard(key string) *cacheShard {
+	shards := c.loadShards()
+	switch len(shards) {
+	case 0:
+		return nil
+	case 1:
+		return shards[0]
+	}
+	// FNV-1a.
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return shards[h%uint32(len(shards))]
+}
+
+// init splits the cache into shards as opts say. It does nothing if
+// the cache has already been split.
+func (c *cache) init(opts cacheOptions) {
+	c.initMu.Lock()
+	defer c.initMu.Unlock()
+	if c.loadShards() != nil {
+		return
+	}
+	n, policy := opts.shards, opts.policy
+	if n < 1 {
+		n = 1
+	}
+	if policy == nil {
+		policy = evict.LRU
+	}
+	shards := make([]*cacheShard, n)
+	for i := range shards {
+		sh := &cacheShard{}
+		sh.entries = policy(func(key string, value interface{}) {
+			c.addBytes(sh, -int64(len(key))-int64(value.(ByteView).Len()))
+			sh.nevict++
+			if sh.reason == EvictCapacity {
+				sh.victim = &cacheEntry{key, value.(ByteView)}
+			}
+			if opts.evicted != nil {
+				opts.evicted(key, sh.reason)
+			}
+		})
+		shards[i] = sh
+	}
+	c.shards.Store(shards)
+}
+
+// addBytes adds n to the byte counts of the cache and of sh, whose
+// lock must be held.
+func (c *cache) addBytes(sh *cacheShard, n int64) {
+	sh.nbytes += n
+	atomic.AddInt64(&c.nbytes, n)
 }
 
 func (c *cache) stats() CacheStats {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return CacheStats{
-		Bytes:     c.nbytes,
-		Items:     c.itemsLocked(),
-		Gets:      c.nget,
-		Hits:      c.nhit,
-		Evictions: c.nevict,
+	var s CacheStats
+	for _, sh := range c.loadShards() {
+		sh.mu.RLock()
+		s.Bytes += sh.nbytes
+		s.Items += int64(sh.entries.Len())
+		s.Gets += sh.nget
+		s.Hits += sh.nhit
+		s.Evictions += sh.nevict
+		sh.mu.RUnlock()
+	}
+	return s
+}
+
+// add adds value to the cache. If the cache has never been added to,
+// it is first split into shards as opts say.
+func (c *cache) add(key string, value ByteView, opts cacheOptions) {
+	sh := c.shard(key)
+	if sh == nil {
+		c.init(opts)
+		sh = c.shard(key)
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if vi, ok := sh.entries.Peek(key); ok {
+		// Replacing an existing entry; don't double count the key.
+		c.addBytes(sh, -int64(len(key))-int64(vi.(ByteView).Len()))
+	}
+	sh.entries.Add(key, value)
+	c.addBytes(sh, int64(len(key))+int64(value.Len()))
+}
+
+// get returns the value for key. Entries that expired more than
+// stale ago are removed and reported as misses.
+func (c *cache) get(key string, stale time.Duration) (value ByteView, ok bool) {
+	sh := c.shard(key)
+	if sh == nil {
+		return
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.nget++
+	vi, ok := sh.entries.Get(key)
+	if !ok {
+		return
+	}
+	value = vi.(ByteView)
+	if value.expired(time.Now().Add(-stale)) {
+		sh.reason = EvictExpired
+		sh.entries.Remove(key)
+		return ByteView{}, false
+	}
+	sh.nhit++
+	return value, true
+}
+
+// walk calls fn for each entry in the cache, shard by shard, in the
+// order of the shard's evict.Cache Walk.
+func (c *cache) walk(fn func(key string, value ByteView)) {
+	type kv struct {
+		key   string
+		value ByteView
+	}
+	for _, sh := range c.loadShards() {
+		sh.mu.RLock()
+		entries := make([]kv, 0, sh.entries.Len())
+		sh.entries.Walk(func(key string, value interface{}) {
+			entries = append(entries, kv{key, value.(ByteView)})
+		})
+		sh.mu.RUnlock()
+		for _, e := range entries {
+			fn(e.key, e.value)
+		}
+	}
+}
+
+// has reports whether key is in the cache, without counting a use.
+func (c *cache) has(key string) bool {
+	sh := c.shard(key)
+	if sh == nil {
+		return false
 	}
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	_, ok := sh.entries.Peek(key)
+	return ok
 }
 
-func (c *cache) add(key string, value ByteView) {
+func (c *cache) remove(key string) {
+	if sh := c.shard(key); sh != nil {
+		sh.mu.Lock()
+		sh.reason = EvictRemoved
+		sh.entries.Remove(key)
+		sh.mu.Unlock()
+	}
+}
+
+// removeOldest evicts an entry from the shard holding the most bytes,
+// which keeps eviction roughly in the order a single shard would use.
+// It returns the evicted entry, or nil if the cache is empty.
+func (c *cache) removeOldest() *cacheEntry {
+	var victim *cacheShard
+	most := int64(-1)
+	for _, sh := range c.loadShards() {
+		sh.mu.RLock()
+		if sh.entries.Len() > 0 && sh.nbytes > most {
+			victim, most = sh, sh.nbytes
+		}
+		sh.mu.RUnlock()
+	}
+	if victim == nil {
+		return nil
+	}
+	victim.mu.Lock()
+	defer victim.mu.Unlock()
+	victim.reason = EvictCapacity
+	victim.entries.RemoveOldest()
+	e := victim.victim
+	victim.victim = nil
+	return e
+}
+
+func (c *cache) bytes() int64 {
+	return atomic.LoadInt64(&c.nbytes)
+}
+
+func (c *cache) items() int64 {
+	var n int64
+	for _, sh := range c.loadShards() {
+		sh.mu.RLock()
+		n += int64(sh.entries.Len())
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// maxNegativeEntries is the number of errors a negativeCache holds
+// before evicting the least recently used.
+const maxNegativeEntries = 1 << 12
+
+// negativeCache is a bounded cache of errors from failed loads.
+type negativeCache struct {
+	mu  sync.Mutex
+	lru *lru.Cache
+}
+
+type negativeEntry struct {
+	err    error
+	expire time.Time
+}
+
+func (c *negativeCache) add(key string, err error, expire time.Time) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru == nil {
-		c.lru = &lru.Cache{
-			OnEvicted: func(key lru.Key, value interface{}) {
-				val := value.(ByteView)
-				c.nbytes -= int64(len(key.(string))) + int64(val.Len())
-				c.nevict++
-			},
-		}
+		c.lru = lru.New(maxNegativeEntries)
 	}
-	c.lru.Add(key, value)
-	c.nbytes += int64(len(key)) + int64(value.Len())
+	c.lru.Add(key, negativeEntry{err, expire})
 }
 
-func (c *cache) get(key string) (value ByteView, ok bool) {
+// get returns the unexpired error cached for key, or nil.
+func (c *negativeCache) get(key string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.nget++
 	if c.lru == nil {
-		return
+		return nil
 	}
 	vi, ok := c.lru.Get(key)
 	if !ok {
-		return
+		return nil
 	}
-	c.nhit++
-	return vi.(ByteView), true
+	e := vi.(negativeEntry)
+	if !time.Now().Before(e.expire) {
+		c.lru.Remove(key)
+		return nil
+	}
+	return e.err
 }
 
-func (c *cache) removeOldest() {
+func (c *negativeCache) remove(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru != nil {
-		c.lru.RemoveOldest()
+		c.lru.Remove(key)
 	}
 }
 
-func (c *cache) bytes() int64 {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.nbytes
+// maxRateEntries is the number of keys whose request rates a
+// rateTracker tracks before forgetting the least recently requested.
+const maxRateEntries = 1 << 12
+
+// rateTracker estimates how often each key is requested, over
+// roughly the last minute.
+type rateTracker struct {
+	mu  sync.Mutex
+	lru *lru.Cache
 }
 
-func (c *cache) items() int64 {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.itemsLocked()
+// keyRate counts the requests for a key in the current and previous
+// minutes.
+type keyRate struct {
+	start     time.Time // of the current minute
+	cur, prev int64
 }
 
-func (c *cache) itemsLocked() int64 {
-	if c.lru == nil {
-		return 0
+// observe records a request for key at now and returns the key's
+// request rate, in queries per second.
+func (t *rateTracker) observe(key string, now time.Time) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lru == nil {
+		t.lru = lru.New(maxRateEntries)
+	}
+	var r *keyRate
+	if vi, ok := t.lru.Get(key); ok {
+		r = vi.(*keyRate)
+	} else {
+		r = &keyRate{start: now}
+		t.lru.Add(key, r)
+	}
+	switch elapsed := now.Sub(r.start); {
+	case elapsed >= 2*time.Minute:
+		r.start, r.cur, r.prev = now, 0, 0
+	case elapsed >= time.Minute:
+		r.start, r.cur, r.prev = r.start.Add(time.Minute), 0, r.cur
 	}
-	return int64(c.lru.Len())
+	r.cur++
+	// Count the part of the previous minute that is still within
+	// the last minute.
+	frac := float64(now.Sub(r.start)) / float64(time.Minute)
+	return (float64(r.prev)*(1-frac) + float64(r.cur)) / 60
 }
 
 // An AtomicInt is an int64 to be accessed atomically.
@@ -445,6 +1460,33 @@ func (i *AtomicInt) String() string {
 	return strconv.FormatInt(i.Get(), 10)
 }
 
+// Histograms are the distributions a Group keeps of its latencies,
+// in nanoseconds, and value sizes, in bytes.
+type Histograms struct {
+	LocalLoad Histogram // calls to the Getter, including failed ones
+	PeerFetch Histogram // requests to peers, including failed ones
+	LoadWait  Histogram // waits for a load, whether led or shared
+	ValueSize Histogram // values loaded locally or from peers
+}
+
+// HistogramsSnapshot is a snapshot of a Group's Histograms.
+type HistogramsSnapshot struct {
+	LocalLoad HistogramSnapshot
+	PeerFetch HistogramSnapshot
+	LoadWait  HistogramSnapshot
+	ValueSize HistogramSnapshot
+}
+
+// Snapshot returns the distributions observed so far.
+func (h *Histograms) Snapshot() HistogramsSnapshot {
+	return HistogramsSnapshot{
+		LocalLoad: h.LocalLoad.Snapshot(),
+		PeerFetch: h.PeerFetch.Snapshot(),
+		LoadWait:  h.LoadWait.Snapshot(),
+		ValueSize: h.ValueSize.Snapshot(),
+	}
+}
+
 // CacheStats are returned by stats accessors on Group.
 type CacheStats struct {
 	Bytes     int64
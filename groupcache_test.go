@@ -19,17 +19,22 @@ limitations under the License.
 package groupcache
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"math/rand"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"code.google.com/p/goprotobuf/proto"
 
+	"github.com/golang/groupcache/evict"
 	pb "github.com/golang/groupcache/groupcachepb"
 	testpb "github.com/golang/groupcache/testpb"
 )
@@ -201,7 +206,7 @@ func TestCacheEviction(t *testing.T) {
 	}
 
 	g := stringGroup.(*Group)
-	evict0 := g.mainCache.nevict
+	evict0 := g.mainCache.stats().Evictions
 
 	// Trash the cache with other keys.
 	var bytesFlooded int64
@@ -212,7 +217,7 @@ func TestCacheEviction(t *testing.T) {
 		stringGroup.Get(dummyCtx, key, StringSink(&res))
 		bytesFlooded += int64(len(key) + len(res))
 	}
-	evicts := g.mainCache.nevict - evict0
+	evicts := g.mainCache.stats().Evictions - evict0
 	if evicts <= 0 {
 		t.Errorf("evicts = %v; want more than 0", evicts)
 	}
@@ -224,13 +229,151 @@ func TestCacheEviction(t *testing.T) {
 	}
 }
 
+func TestExpiry(t *testing.T) {
+	const ttl = 50 * time.Millisecond
+	var fills int
+	g := newGroup("TestExpiry-group", cacheSize, GetterFunc(func(_ Context, key string, dest Sink) error {
+		fills++
+		dest.SetExpiry(time.Now().Add(ttl))
+		return dest.SetString("ECHO:" + key)
+	}), NoPeers{})
+	get := func() ByteView {
+		var v ByteView
+		if err := g.Get(dummyCtx, "key", ByteViewSink(&v)); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	v := get()
+	if v.Expire().IsZero() {
+		t.Error("value has no expiry time")
+	}
+	get()
+	if fills != 1 {
+		t.Fatalf("fills before expiry = %d; want 1", fills)
+	}
+	time.Sleep(2 * ttl)
+	if _, ok := g.lookupCache("key"); ok {
+		t.Error("lookupCache returned an expired entry")
+	}
+	get()
+	if fills != 2 {
+		t.Errorf("fills after expiry = %d; want 2", fills)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	const ttl = 20 * time.Millisecond
+	var fills AtomicInt
+	var failing int32 // atomic
+	g := newGroup("TestStaleWhileRevalidate-group", cacheSize, GetterFunc(func(_ Context, key string, dest Sink) error {
+		if atomic.LoadInt32(&failing) != 0 {
+			return errors.New("simulated load failure")
+		}
+		fills.Add(1)
+		dest.SetExpiry(time.Now().Add(ttl))
+		return dest.SetString(fmt.Sprintf("v%d", fills.Get()))
+	}), NoPeers{})
+	g.StaleWhileRevalidate = time.Minute
+
+	get := func() string {
+		var s string
+		if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	waitRefresh := func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			g.refreshMu.Lock()
+			n := len(g.refreshing)
+			g.refreshMu.Unlock()
+			if n == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("timeout waiting for refresh")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if got := get(); got != "v1" {
+		t.Fatalf("first Get = %q; want v1", got)
+	}
+	time.Sleep(2 * ttl)
+	if got := get(); got != "v1" {
+		t.Errorf("stale Get = %q; want v1", got)
+	}
+	waitRefresh()
+	if got := get(); got != "v2" {
+		t.Errorf("Get after refresh = %q; want v2", got)
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	time.Sleep(2 * ttl)
+	if got := get(); got != "v2" {
+		t.Errorf("stale Get = %q; want v2", got)
+	}
+	waitRefresh()
+	if got := get(); got != "v2" {
+		t.Errorf("Get after failed refresh = %q; want v2", got)
+	}
+	if got := g.Stats.StaleHits.Get(); got != 3 {
+		t.Errorf("StaleHits = %d; want 3", got)
+	}
+}
+
 type fakePeer struct {
-	hits int
-	fail bool
+	mu      sync.Mutex // guards hits, for concurrent GetMulti fetches
+	hits    int
+	batches int
+	removes int
+	sets    map[string]string
+	fail    bool
+}
+
+// multiPeer is a fakePeer that supports batched requests.
+type multiPeer struct {
+	fakePeer
+}
+
+func (p *multiPeer) GetMulti(_ Context, in *pb.GetMultiRequest, out *pb.GetMultiResponse) error {
+	p.batches++
+	if p.fail {
+		return errors.New("simulated error from peer")
+	}
+	for _, key := range in.GetKey() {
+		p.hits++
+		out.Response = append(out.Response, &pb.GetResponse{Value: []byte("got:" + key)})
+	}
+	return nil
+}
+
+func (p *fakePeer) Set(_ Context, in *pb.SetRequest, out *pb.SetResponse) error {
+	if p.fail {
+		return errors.New("simulated error from peer")
+	}
+	if p.sets == nil {
+		p.sets = make(map[string]string)
+	}
+	p.sets[in.GetKey()] = string(in.GetValue())
+	return nil
+}
+
+func (p *fakePeer) Remove(_ Context, in *pb.RemoveRequest, out *pb.RemoveResponse) error {
+	p.removes++
+	if p.fail {
+		return errors.New("simulated error from peer")
+	}
+	return nil
 }
 
 func (p *fakePeer) Get(_ Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	p.mu.Lock()
 	p.hits++
+	p.mu.Unlock()
 	if p.fail {
 		return errors.New("simulated error from peer")
 	}
@@ -248,6 +391,16 @@ func (p fakePeers) PickPeer(key string) (peer ProtoGetter, ok bool) {
 	return p[n], p[n] != nil
 }
 
+func (p fakePeers) ListPeers() []ProtoGetter {
+	var peers []ProtoGetter
+	for _, peer := range p {
+		if peer != nil {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
 // tests that peers (virtual, in-process) are hit, and how much.
 func TestPeers(t *testing.T) {
 	once.Do(testSetup)
@@ -320,6 +473,821 @@ func TestPeers(t *testing.T) {
 	run("peer0_failing", 200, "localHits = 100, peers = 51 49 51")
 }
 
+type blockingPeer struct{}
+
+func (blockingPeer) Get(ctx Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// tests that a cancelled caller abandons a stuck peer request and
+// does not fall back to a local load.
+func TestPeerCancellation(t *testing.T) {
+	localHits := 0
+	getter := func(_ Context, key string, dest Sink) error {
+		localHits++
+		return dest.SetString("got:" + key)
+	}
+	g := newGroup("TestPeerCancellation-group", 1<<20, GetterFunc(getter), fakePeers{blockingPeer{}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var got string
+	err := g.Get(ctx, "key", StringSink(&got))
+	if err != context.DeadlineExceeded {
+		t.Errorf("Get error = %v; want %v", err, context.DeadlineExceeded)
+	}
+	if localHits != 0 {
+		t.Errorf("localHits = %d; want 0", localHits)
+	}
+}
+
+// tests that a caller waiting on another caller's load stops waiting
+// when its own context is cancelled.
+func TestGetCancelWhileWaiting(t *testing.T) {
+	release := make(chan bool)
+	started := make(chan bool)
+	getter := func(_ Context, key string, dest Sink) error {
+		started <- true
+		<-release
+		return dest.SetString("got:" + key)
+	}
+	g := newGroup("TestGetCancelWhileWaiting-group", 1<<20, GetterFunc(getter), NoPeers{})
+
+	go func() {
+		var s string
+		g.Get(context.Background(), "key", StringSink(&s))
+	}()
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		var s string
+		errc <- g.Get(ctx, "key", StringSink(&s))
+	}()
+	cancel()
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Errorf("Get error = %v; want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("timeout waiting for cancelled Get to return")
+	}
+	close(release)
+}
+
+// ringPeers is a MultiPeerPicker whose every key has the same owners,
+// in order. A nil entry stands for the current process.
+type ringPeers []ProtoGetter
+
+func (p ringPeers) PickPeer(key string) (ProtoGetter, bool) {
+	return p[0], p[0] != nil
+}
+
+func (p ringPeers) PickPeers(key string, n int) ([]ProtoGetter, bool) {
+	var peers []ProtoGetter
+	for _, peer := range p {
+		if len(peers) == n {
+			break
+		}
+		if peer == nil {
+			return peers, true
+		}
+		peers = append(peers, peer)
+	}
+	return peers, false
+}
+
+// tests that loads fall back to a key's secondary owners, and to the
+// Getter only as the FetchPolicy allows.
+func TestFetchPolicy(t *testing.T) {
+	bad := &fakePeer{fail: true}
+	good := &fakePeer{}
+	localHits := 0
+	getter := func(_ Context, key string, dest Sink) error {
+		localHits++
+		return dest.SetString("local:" + key)
+	}
+	tests := []struct {
+		name      string
+		peers     ringPeers
+		policy    FetchPolicy
+		want      string // or "error"
+		peerErrs  int64
+		localHits int
+	}{
+		{"owner_only", ringPeers{bad, good}, FetchPolicy{}, "local:key", 1, 1},
+		{"secondary", ringPeers{bad, good}, FetchPolicy{Owners: 2}, "got:key", 1, 0},
+		{"all_failed", ringPeers{bad, bad, good}, FetchPolicy{Owners: 2}, "local:key", 2, 1},
+		{"fail_fast", ringPeers{bad, bad, good}, FetchPolicy{Owners: 2, FailFast: true}, "error", 2, 0},
+		{"fail_fast_self", ringPeers{bad, nil, good}, FetchPolicy{Owners: 3, FailFast: true}, "local:key", 1, 1},
+	}
+	for _, tt := range tests {
+		localHits = 0
+		g := newGroup("TestFetchPolicy-"+tt.name, 1<<20, GetterFunc(getter), tt.peers)
+		g.FetchPolicy = tt.policy
+		var got string
+		err := g.Get(dummyCtx, "key", StringSink(&got))
+		if err != nil {
+			got = "error"
+		}
+		if got != tt.want {
+			t.Errorf("%s: Get = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+		if n := g.Stats.PeerErrors.Get(); n != tt.peerErrs {
+			t.Errorf("%s: PeerErrors = %d; want %d", tt.name, n, tt.peerErrs)
+		}
+		if localHits != tt.localHits {
+			t.Errorf("%s: localHits = %d; want %d", tt.name, localHits, tt.localHits)
+		}
+	}
+}
+
+// tests that a slow owner's request is hedged to the next owner.
+func TestFetchPolicyHedge(t *testing.T) {
+	getter := func(_ Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}
+	g := newGroup("TestFetchPolicyHedge-group", 1<<20, GetterFunc(getter), ringPeers{blockingPeer{}, &fakePeer{}})
+	g.FetchPolicy = FetchPolicy{Owners: 2, HedgeAfter: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var got string
+	if err := g.Get(ctx, "key", StringSink(&got)); err != nil {
+		t.Fatal(err)
+	}
+	if got != "got:key" {
+		t.Errorf("Get = %q; want %q", got, "got:key")
+	}
+}
+
+// ratePeer answers with the request rate for each key given in qps.
+type ratePeer map[string]float64
+
+func (p ratePeer) Get(_ Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	out.Value = []byte("got:" + in.GetKey())
+	out.MinuteQps = proto.Float64(p[in.GetKey()])
+	return nil
+}
+
+// tests that only keys the owner reports as hot are mirrored.
+func TestHotCacheQPS(t *testing.T) {
+	getter := func(_ Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}
+	g := newGroup("TestHotCacheQPS-group", 1<<20, GetterFunc(getter), fakePeers{ratePeer{"hot": 20, "cold": 0.5}})
+	g.HotCacheQPS = 10
+	for i := 0; i < 10; i++ {
+		for _, key := range []string{"hot", "cold"} {
+			var got string
+			if err := g.Get(dummyCtx, key, StringSink(&got)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if n := g.Stats.PeerLoads.Get(); n != 11 {
+		t.Errorf("PeerLoads = %d; want 11", n)
+	}
+	if n := g.CacheStats(HotCache).Items; n != 1 {
+		t.Errorf("hot cache items = %d; want 1", n)
+	}
+}
+
+// tests that a group mirrors values as its HotCachePolicy says.
+func TestHotCachePolicy(t *testing.T) {
+	getter := func(_ Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}
+	for _, mirror := range []bool{false, true} {
+		peer := &fakePeer{}
+		g := newGroup(fmt.Sprintf("TestHotCachePolicy-%v", mirror), 1<<20, GetterFunc(getter), fakePeers{peer})
+		g.HotCachePolicy = FixedHotCachePolicy{Mirror: mirror, HotRatio: 1}
+		for i := 0; i < 2; i++ {
+			for _, key := range testKeys(10) {
+				var got string
+				if err := g.Get(dummyCtx, key, StringSink(&got)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		wantHits, wantItems := 20, int64(0)
+		if mirror {
+			wantHits, wantItems = 10, 10
+		}
+		if peer.hits != wantHits {
+			t.Errorf("mirror=%v: peer hits = %d; want %d", mirror, peer.hits, wantHits)
+		}
+		if n := g.CacheStats(HotCache).Items; n != wantItems {
+			t.Errorf("mirror=%v: hot cache items = %d; want %d", mirror, n, wantItems)
+		}
+	}
+
+	victims := []struct {
+		policy              HotCachePolicy
+		mainBytes, hotBytes int64
+		want                CacheType
+	}{
+		{DefaultHotCachePolicy{}, 800, 100, MainCache},
+		{DefaultHotCachePolicy{}, 800, 101, HotCache},
+		{FixedHotCachePolicy{HotRatio: 0.5}, 100, 50, MainCache},
+		{FixedHotCachePolicy{HotRatio: 0.5}, 100, 60, HotCache},
+	}
+	for _, v := range victims {
+		if got := v.policy.Victim(v.mainBytes, v.hotBytes); got != v.want {
+			t.Errorf("%#v.Victim(%d, %d) = %v; want %v", v.policy, v.mainBytes, v.hotBytes, got, v.want)
+		}
+	}
+}
+
+// tests that the main cache evicts as the group's EvictionPolicy says.
+func TestEvictionPolicy(t *testing.T) {
+	getter := func(_ Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}
+	for _, tt := range []struct {
+		name   string
+		policy evict.Policy
+		kept   bool
+	}{
+		{"LRU", nil, false},
+		{"LFU", evict.LFU, true},
+	} {
+		// Room for about 10 keys and values.
+		g := newGroup("TestEvictionPolicy-"+tt.name, 150, GetterFunc(getter), nil)
+		g.EvictionPolicy = tt.policy
+		var s string
+		for i := 0; i < 5; i++ {
+			g.Get(dummyCtx, "popular", StringSink(&s))
+		}
+		for i := 0; i < 50; i++ {
+			g.Get(dummyCtx, fmt.Sprintf("scan-%d", i), StringSink(&s))
+		}
+		hits := g.Stats.CacheHits.Get()
+		g.Get(dummyCtx, "popular", StringSink(&s))
+		if kept := g.Stats.CacheHits.Get() > hits; kept != tt.kept {
+			t.Errorf("%s: popular key kept = %v; want %v", tt.name, kept, tt.kept)
+		}
+	}
+}
+
+// tests that a sharded cache accounts for and evicts keys across all
+// of its shards.
+func TestCacheShards(t *testing.T) {
+	const cacheBytes = 1 << 10
+	getter := func(_ Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}
+	g := newGroup("TestCacheShards-group", cacheBytes, GetterFunc(getter), nil)
+	g.CacheShards = 8
+	var s string
+	for _, key := range testKeys(1000) {
+		g.Get(dummyCtx, key, StringSink(&s))
+		if n := g.mainCache.bytes(); n > cacheBytes {
+			t.Fatalf("cache bytes = %d; want at most %d", n, cacheBytes)
+		}
+	}
+	shards := g.mainCache.loadShards()
+	if len(shards) != 8 {
+		t.Fatalf("cache has %d shards; want 8", len(shards))
+	}
+	var bytes int64
+	for i, sh := range shards {
+		if sh.entries.Len() == 0 {
+			t.Errorf("shard %d is empty", i)
+		}
+		bytes += sh.nbytes
+	}
+	st := g.CacheStats(MainCache)
+	if st.Bytes != bytes || st.Bytes != g.mainCache.bytes() {
+		t.Errorf("CacheStats.Bytes = %d; shards hold %d, cache counts %d", st.Bytes, bytes, g.mainCache.bytes())
+	}
+	if st.Items+st.Evictions != 1000 {
+		t.Errorf("CacheStats.Items + Evictions = %d; want 1000", st.Items+st.Evictions)
+	}
+
+	// The most recently used keys are still cached.
+	hits := g.Stats.CacheHits.Get()
+	g.Get(dummyCtx, "999", StringSink(&s))
+	if g.Stats.CacheHits.Get() != hits+1 {
+		t.Error("most recent key was evicted")
+	}
+}
+
+func BenchmarkCacheHits(b *testing.B) {
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			g := NewRegistry().newGroup("BenchmarkCacheHits-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+				return dest.SetString("value")
+			}), NoPeers{})
+			g.CacheShards = shards
+			keys := testKeys(1000)
+			var s string
+			for _, key := range keys {
+				g.Get(dummyCtx, key, StringSink(&s))
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var s string
+				for i := 0; pb.Next(); i++ {
+					g.Get(dummyCtx, keys[i%len(keys)], StringSink(&s))
+				}
+			})
+		})
+	}
+}
+
+// recordingObserver records the events it observes.
+type recordingObserver struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (o *recordingObserver) record(format string, args ...interface{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, fmt.Sprintf(format, args...))
+}
+
+func (o *recordingObserver) CacheHit(key string, cache CacheType) {
+	o.record("hit %s %d", key, cache)
+}
+
+func (o *recordingObserver) PeerFetchStart(key, peer string) {
+	o.record("fetch %s from %s", key, peer)
+}
+
+func (o *recordingObserver) PeerFetchDone(key, peer string, d time.Duration, err error) {
+	o.record("fetched %s from %s: %v", key, peer, err)
+}
+
+func (o *recordingObserver) LocalLoad(key string, d time.Duration, err error) {
+	o.record("load %s: %v", key, err)
+}
+
+func (o *recordingObserver) Evicted(key string, cache CacheType, reason EvictReason) {
+	o.record("evict %s %d %v", key, cache, reason)
+}
+
+func TestObserver(t *testing.T) {
+	getter := func(_ Context, key string, dest Sink) error {
+		if key == "bad" {
+			return errors.New("bad key")
+		}
+		return dest.SetString("value")
+	}
+	obs := &recordingObserver{}
+	g := newGroup("TestObserver-group", 1<<20, GetterFunc(getter), ringPeers{nil})
+	g.Observer = obs
+	var s string
+	g.Get(dummyCtx, "a", StringSink(&s))
+	g.Get(dummyCtx, "a", StringSink(&s))
+	g.Get(dummyCtx, "bad", StringSink(&s))
+	g.Set(dummyCtx, "b", []byte("set"), time.Now().Add(10*time.Millisecond), false)
+	time.Sleep(20 * time.Millisecond)
+	g.Get(dummyCtx, "b", StringSink(&s))
+	g.Remove(dummyCtx, "a")
+
+	g = newGroup("TestObserver-remote", 30, GetterFunc(getter), ringPeers{&fakePeer{}})
+	g.Observer = obs
+	g.HotCachePolicy = FixedHotCachePolicy{Mirror: true}
+	g.Get(dummyCtx, "remote-1", StringSink(&s))
+	g.Get(dummyCtx, "remote-2", StringSink(&s))
+
+	want := []string{
+		"load a: <nil>",
+		"hit a 1",
+		"load bad: bad key",
+		"evict b 1 expired",
+		"load b: <nil>",
+		"evict a 1 removed",
+		"fetch remote-1 from *groupcache.fakePeer",
+		"fetched remote-1 from *groupcache.fakePeer: <nil>",
+		"fetch remote-2 from *groupcache.fakePeer",
+		"evict remote-1 2 capacity",
+		"fetched remote-2 from *groupcache.fakePeer: <nil>",
+	}
+	if !reflect.DeepEqual(obs.events, want) {
+		t.Errorf("events:\n%s\nwant:\n%s", strings.Join(obs.events, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRateTracker(t *testing.T) {
+	var r rateTracker
+	start := time.Now()
+	var qps float64
+	for i := 0; i < 120; i++ {
+		qps = r.observe("key", start.Add(time.Duration(i)*time.Second/2))
+	}
+	if qps != 2 {
+		t.Errorf("after 1 minute at 2 qps, rate = %v; want 2", qps)
+	}
+	for i := 0; i < 60; i++ {
+		qps = r.observe("key", start.Add(time.Minute+time.Duration(i)*time.Second/2))
+	}
+	if qps < 1.9 || qps > 2.1 {
+		t.Errorf("after 90 seconds at 2 qps, rate = %v; want about 2", qps)
+	}
+	if qps = r.observe("key", start.Add(time.Hour)); qps != 1.0/60 {
+		t.Errorf("after an idle hour, rate = %v; want %v", qps, 1.0/60)
+	}
+	if qps = r.observe("other", start); qps != 1.0/60 {
+		t.Errorf("rate of new key = %v; want %v", qps, 1.0/60)
+	}
+}
+
+func TestSetCacheBytes(t *testing.T) {
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString(strings.Repeat("x", 10))
+	})
+	g := newGroup("TestSetCacheBytes-group", 1<<20, getter, nil)
+	var s string
+	for i := 0; i < 20; i++ {
+		g.Get(dummyCtx, strconv.Itoa(i), StringSink(&s))
+	}
+	if n := g.mainCache.items(); n != 20 {
+		t.Fatalf("cache holds %d items; want 20", n)
+	}
+	g.SetCacheBytes(100)
+	if n := g.mainCache.bytes(); n > 100 {
+		t.Errorf("after SetCacheBytes(100), cache holds %d bytes", n)
+	}
+	if n := g.CacheBytes(); n != 100 {
+		t.Errorf("CacheBytes = %d; want 100", n)
+	}
+	// The most recently used keys survive.
+	if _, ok := g.mainCache.get("19", 0); !ok {
+		t.Error("most recent key was evicted")
+	}
+	g.SetCacheBytes(0)
+	if n := g.mainCache.items(); n != 0 {
+		t.Errorf("after SetCacheBytes(0), cache holds %d items", n)
+	}
+	g.Get(dummyCtx, "0", StringSink(&s))
+	if n := g.mainCache.items(); n != 0 {
+		t.Errorf("with caching disabled, cache holds %d items", n)
+	}
+}
+
+func TestNewGroupWithOptions(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterPeerPicker(func() PeerPicker {
+		t.Error("registry's PeerPicker used by a group with its own")
+		return NoPeers{}
+	})
+	peer := &fakePeer{}
+	remote := r.NewGroupWithOptions("remote", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("local")
+	}), GroupOptions{
+		Peers:              ringPeers{peer},
+		HotFillProbability: 1,
+		HotCacheRatio:      1,
+	})
+	var s string
+	if err := remote.Get(dummyCtx, "key", StringSink(&s)); err != nil || s != "got:key" {
+		t.Fatalf("Get = %q, %v; want value from peer", s, err)
+	}
+	if n := remote.hotCache.items(); n != 1 {
+		t.Errorf("hot cache holds %d items; want every fetched value", n)
+	}
+
+	getter := GetterFunc(func(ctx Context, key string, dest Sink) error {
+		if key == "slow" {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return dest.SetString(strings.Repeat("x", len(key)))
+	})
+	local := r.NewGroupWithOptions("local", 1<<20, getter, GroupOptions{
+		Peers:         ringPeers{nil},
+		MaxValueBytes: 5,
+		LoadTimeout:   10 * time.Millisecond,
+		NegativeTTL:   time.Minute,
+	})
+	local.Get(dummyCtx, "short", StringSink(&s))
+	local.Get(dummyCtx, "too long", StringSink(&s))
+	if _, ok := local.mainCache.get("short", 0); !ok {
+		t.Error("value within MaxValueBytes wasn't cached")
+	}
+	if _, ok := local.mainCache.get("too long", 0); ok {
+		t.Error("value over MaxValueBytes was cached")
+	}
+	// A Set too big to cache doesn't leave the old value behind.
+	if err := local.Set(dummyCtx, "short", []byte("much too long"), time.Time{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Get(dummyCtx, "short", StringSink(&s)); err != nil || s != "xxxxx" {
+		t.Errorf("Get after an oversized Set = %q, %v; want the value reloaded", s, err)
+	}
+	if err := local.Set(dummyCtx, "short", []byte("new"), time.Now().Add(-time.Second), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := local.mainCache.get("short", 0); ok {
+		t.Error("old value left cached by a Set of an expired value")
+	}
+	if err := local.Get(dummyCtx, "slow", StringSink(&s)); err != ErrLoadTimeout {
+		t.Errorf("Get of slow key = %v; want ErrLoadTimeout", err)
+	}
+	if err := local.negCache.get("slow"); err != nil {
+		t.Errorf("load timeout was cached: %v", err)
+	}
+}
+
+func TestGroupHistograms(t *testing.T) {
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		time.Sleep(5 * time.Millisecond)
+		return dest.SetBytes(make([]byte, 100))
+	})
+	g := newGroup("TestGroupHistograms-local", 1<<20, getter, ringPeers{nil})
+	var s string
+	for _, key := range []string{"a", "b", "a"} {
+		g.Get(dummyCtx, key, StringSink(&s))
+	}
+	h := g.Histograms.Snapshot()
+	if h.LocalLoad.Count != 2 || h.LoadWait.Count != 2 || h.PeerFetch.Count != 0 {
+		t.Errorf("local, wait, peer counts = %d, %d, %d; want 2, 2, 0", h.LocalLoad.Count, h.LoadWait.Count, h.PeerFetch.Count)
+	}
+	if p := time.Duration(h.LocalLoad.Percentile(50)); p < 5*time.Millisecond {
+		t.Errorf("median local load = %v; want at least 5ms", p)
+	}
+	if p := time.Duration(h.LoadWait.Percentile(99)); p < 5*time.Millisecond {
+		t.Errorf("99th percentile load wait = %v; want at least 5ms", p)
+	}
+	if h.ValueSize.Count != 2 || h.ValueSize.Sum != 200 {
+		t.Errorf("value sizes = %+v; want 2 of 100 bytes", h.ValueSize)
+	}
+
+	g = newGroup("TestGroupHistograms-remote", 1<<20, getter, ringPeers{&fakePeer{}})
+	g.Get(dummyCtx, "key", StringSink(&s))
+	h = g.Histograms.Snapshot()
+	if h.PeerFetch.Count != 1 || h.LocalLoad.Count != 0 || h.ValueSize.Sum != int64(len("got:key")) {
+		t.Errorf("after peer fetch, histograms = %+v", h)
+	}
+}
+
+// tests that GetMulti falls back to a key's secondary owner.
+func TestGetMultiFallback(t *testing.T) {
+	getter := func(_ Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}
+	ctx := context.Background()
+	keys := []string{"a", "b"}
+	vals := make([]string, len(keys))
+	g := newGroup("TestGetMultiFallback-group", 1<<20, GetterFunc(getter), ringPeers{&fakePeer{fail: true}, &fakePeer{}})
+	g.FetchPolicy = FetchPolicy{Owners: 2}
+	if err := g.GetMulti(ctx, keys, []Sink{StringSink(&vals[0]), StringSink(&vals[1])}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(vals) != "[got:a got:b]" {
+		t.Errorf("GetMulti = %v; want [got:a got:b]", vals)
+	}
+}
+
+// tests that Remove forwards to the key's owner, or fans out to all
+// peers when the current process is the owner.
+func TestRemove(t *testing.T) {
+	peer0 := &fakePeer{}
+	peer1 := &fakePeer{}
+	peer2 := &fakePeer{}
+	peerList := fakePeers([]ProtoGetter{peer0, peer1, peer2, nil})
+	getter := func(_ Context, key string, dest Sink) error {
+		return dest.SetString("got:" + key)
+	}
+	g := newGroup("TestRemove-group", cacheSize, GetterFunc(getter), peerList)
+
+	// keyFor returns a key owned by peerList[i].
+	keyFor := func(i int) string {
+		for n := 0; ; n++ {
+			key := fmt.Sprintf("key-%d", n)
+			if crc32.Checksum([]byte(key), crc32.IEEETable)%uint32(len(peerList)) == uint32(i) {
+				return key
+			}
+		}
+	}
+	removes := func() string {
+		return fmt.Sprintf("%d %d %d", peer0.removes, peer1.removes, peer2.removes)
+	}
+
+	remoteKey := keyFor(1)
+	g.populateCache(remoteKey, ByteView{s: "hot"}, &g.hotCache)
+	if err := g.Remove(dummyCtx, remoteKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.lookupCache(remoteKey); ok {
+		t.Errorf("%q still cached after Remove", remoteKey)
+	}
+	if got, want := removes(), "0 1 0"; got != want {
+		t.Errorf("peer removes after removing remote key = %q; want %q", got, want)
+	}
+
+	localKey := keyFor(3)
+	var s string
+	if err := g.Get(dummyCtx, localKey, StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.lookupCache(localKey); !ok {
+		t.Fatalf("%q not cached after Get", localKey)
+	}
+	if err := g.Remove(dummyCtx, localKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.lookupCache(localKey); ok {
+		t.Errorf("%q still cached after Remove", localKey)
+	}
+	if got, want := removes(), "1 2 1"; got != want {
+		t.Errorf("peer removes after removing local key = %q; want %q", got, want)
+	}
+
+	peer2.fail = true
+	if err := g.Remove(dummyCtx, localKey); err == nil {
+		t.Error("Remove with a failing peer succeeded; want error")
+	}
+}
+
+// tests that Set installs the value at the key's owner.
+func TestSet(t *testing.T) {
+	peer0 := &fakePeer{}
+	peer1 := &fakePeer{}
+	peerList := fakePeers([]ProtoGetter{peer0, peer1, nil})
+	var fills int
+	getter := func(_ Context, key string, dest Sink) error {
+		fills++
+		return dest.SetString("got:" + key)
+	}
+	g := newGroup("TestSet-group", cacheSize, GetterFunc(getter), peerList)
+	keyFor := func(i int) string {
+		for n := 0; ; n++ {
+			key := fmt.Sprintf("key-%d", n)
+			if crc32.Checksum([]byte(key), crc32.IEEETable)%uint32(len(peerList)) == uint32(i) {
+				return key
+			}
+		}
+	}
+
+	localKey := keyFor(2)
+	if err := g.Set(dummyCtx, localKey, []byte("set"), time.Time{}, false); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := g.Get(dummyCtx, localKey, StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if s != "set" || fills != 0 {
+		t.Errorf("Get after Set = %q with %d fills; want %q with 0 fills", s, fills, "set")
+	}
+	if peer0.removes != 1 || peer1.removes != 1 {
+		t.Errorf("peer removes = %d %d; want 1 1", peer0.removes, peer1.removes)
+	}
+
+	remoteKey := keyFor(1)
+	if err := g.Set(dummyCtx, remoteKey, []byte("remote"), time.Time{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := peer1.sets[remoteKey]; got != "remote" {
+		t.Errorf("owner got value %q; want %q", got, "remote")
+	}
+	if _, ok := g.lookupCache(remoteKey); ok {
+		t.Error("remote key cached locally without hotCache")
+	}
+	if err := g.Set(dummyCtx, remoteKey, []byte("remote2"), time.Time{}, true); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := g.lookupCache(remoteKey); !ok || v.String() != "remote2" {
+		t.Errorf("hot cache has %q, %v; want %q, true", v, ok, "remote2")
+	}
+}
+
+func TestNegativeCaching(t *testing.T) {
+	const ttl = 50 * time.Millisecond
+	var fills int
+	g := newGroup("TestNegativeCaching-group", cacheSize, GetterFunc(func(_ Context, key string, dest Sink) error {
+		fills++
+		return ErrNotFound
+	}), NoPeers{})
+	g.NegativeTTL = ttl
+
+	for i := 0; i < 3; i++ {
+		var s string
+		if err := g.Get(dummyCtx, "missing", StringSink(&s)); err != ErrNotFound {
+			t.Fatalf("Get error = %v; want ErrNotFound", err)
+		}
+	}
+	if fills != 1 {
+		t.Errorf("fills = %d; want 1", fills)
+	}
+	if got := g.Stats.NegativeHits.Get(); got != 2 {
+		t.Errorf("NegativeHits = %d; want 2", got)
+	}
+
+	time.Sleep(2 * ttl)
+	var s string
+	g.Get(dummyCtx, "missing", StringSink(&s))
+	if fills != 2 {
+		t.Errorf("fills after NegativeTTL = %d; want 2", fills)
+	}
+}
+
+type notFoundPeer struct{}
+
+func (notFoundPeer) Get(_ Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	out.NotFound = proto.Bool(true)
+	out.Expire = proto.Int64(time.Now().Add(time.Hour).UnixNano())
+	return nil
+}
+
+// tests that a not-found answer from the owner is returned, and
+// cached, without falling back to a local load.
+func TestNegativeCachingFromPeer(t *testing.T) {
+	var fills int
+	g := newGroup("TestNegativeCachingFromPeer-group", cacheSize, GetterFunc(func(_ Context, key string, dest Sink) error {
+		fills++
+		return dest.SetString("got:" + key)
+	}), fakePeers{notFoundPeer{}})
+	g.NegativeTTL = time.Minute
+
+	for i := 0; i < 2; i++ {
+		var s string
+		if err := g.Get(dummyCtx, "missing", StringSink(&s)); err != ErrNotFound {
+			t.Fatalf("Get error = %v; want ErrNotFound", err)
+		}
+	}
+	if fills != 0 {
+		t.Errorf("local fills = %d; want 0", fills)
+	}
+	if got := g.Stats.NegativeHits.Get(); got != 1 {
+		t.Errorf("NegativeHits = %d; want 1", got)
+	}
+	if res := g.errorResponse(ErrNotFound); !res.GetNotFound() || res.Expire == nil {
+		t.Errorf("errorResponse(ErrNotFound) = %v; want not_found with expire", res)
+	}
+	if res := g.errorResponse(context.Canceled); res != nil {
+		t.Errorf("errorResponse(context.Canceled) = %v; want nil", res)
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	peer0 := &multiPeer{}
+	peer1 := &multiPeer{}
+	peer2 := &fakePeer{}
+	peerList := fakePeers([]ProtoGetter{peer0, peer1, peer2, nil})
+	var mu sync.Mutex
+	localHits := 0
+	getter := func(_ Context, key string, dest Sink) error {
+		mu.Lock()
+		localHits++
+		mu.Unlock()
+		if key == "key-gone" {
+			return ErrNotFound
+		}
+		return dest.SetString("got:" + key)
+	}
+	g := newGroup("TestGetMulti-group", 0, GetterFunc(getter), peerList)
+
+	run := func(name string, wantSummary string) {
+		localHits = 0
+		peer0.hits, peer0.batches = 0, 0
+		peer1.hits, peer1.batches = 0, 0
+		peer2.hits = 0
+		keys := testKeys(40)
+		values := make([]string, len(keys))
+		dests := make([]Sink, len(keys))
+		for i := range keys {
+			keys[i] = "key-" + keys[i]
+			dests[i] = StringSink(&values[i])
+		}
+		if err := g.GetMulti(dummyCtx, keys, dests); err != nil {
+			t.Fatalf("%s: GetMulti = %v", name, err)
+		}
+		for i, key := range keys {
+			if want := "got:" + key; values[i] != want {
+				t.Errorf("%s: value for %q = %q; want %q", name, key, values[i], want)
+			}
+		}
+		summary := fmt.Sprintf("localHits = %d, peers = %d %d %d, batches = %d %d",
+			localHits, peer0.hits, peer1.hits, peer2.hits, peer0.batches, peer1.batches)
+		if summary != wantSummary {
+			t.Errorf("%s: got %q; want %q", name, summary, wantSummary)
+		}
+	}
+	run("base", "localHits = 9, peers = 11 9 11, batches = 1 1")
+
+	peer0.fail = true
+	run("peer0_failing", "localHits = 20, peers = 0 9 11, batches = 1 1")
+	peer0.fail = false
+
+	values := make([]string, 2)
+	err := g.GetMulti(dummyCtx, []string{"key-1", "key-gone"}, []Sink{StringSink(&values[0]), StringSink(&values[1])})
+	merr, ok := err.(MultiError)
+	if !ok {
+		t.Fatalf("GetMulti error = %v; want MultiError", err)
+	}
+	if merr[0] != nil || merr[1] != ErrNotFound {
+		t.Errorf("GetMulti errors = %v; want [<nil> %v]", []error(merr), ErrNotFound)
+	}
+}
+
 func TestTruncatingByteSliceTarget(t *testing.T) {
 	var buf [100]byte
 	s := buf[:]
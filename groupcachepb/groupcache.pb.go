@@ -16,6 +16,7 @@ var _ = math.Inf
 type GetRequest struct {
 	Group            *string `protobuf:"bytes,1,req,name=group" json:"group,omitempty"`
 	Key              *string `protobuf:"bytes,2,req,name=key" json:"key,omitempty"`
+	AcceptEncoding   *string `protobuf:"bytes,3,opt,name=accept_encoding" json:"accept_encoding,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -37,9 +38,20 @@ func (m *GetRequest) GetKey() string {
 	return ""
 }
 
+func (m *GetRequest) GetAcceptEncoding() string {
+	if m != nil && m.AcceptEncoding != nil {
+		return *m.AcceptEncoding
+	}
+	return ""
+}
+
 type GetResponse struct {
 	Value            []byte   `protobuf:"bytes,1,opt,name=value" json:"value,omitempty"`
 	MinuteQps        *float64 `protobuf:"fixed64,2,opt,name=minute_qps" json:"minute_qps,omitempty"`
+	Expire           *int64   `protobuf:"varint,3,opt,name=expire" json:"expire,omitempty"`
+	NotFound         *bool    `protobuf:"varint,4,opt,name=not_found" json:"not_found,omitempty"`
+	Error            *string  `protobuf:"bytes,5,opt,name=error" json:"error,omitempty"`
+	Encoding         *string  `protobuf:"bytes,6,opt,name=encoding" json:"encoding,omitempty"`
 	XXX_unrecognized []byte   `json:"-"`
 }
 
@@ -61,5 +73,177 @@ func (m *GetResponse) GetMinuteQps() float64 {
 	return 0
 }
 
+func (m *GetResponse) GetExpire() int64 {
+	if m != nil && m.Expire != nil {
+		return *m.Expire
+	}
+	return 0
+}
+
+func (m *GetResponse) GetNotFound() bool {
+	if m != nil && m.NotFound != nil {
+		return *m.NotFound
+	}
+	return false
+}
+
+func (m *GetResponse) GetError() string {
+	if m != nil && m.Error != nil {
+		return *m.Error
+	}
+	return ""
+}
+
+func (m *GetResponse) GetEncoding() string {
+	if m != nil && m.Encoding != nil {
+		return *m.Encoding
+	}
+	return ""
+}
+
+type GetMultiRequest struct {
+	Group            *string  `protobuf:"bytes,1,req,name=group" json:"group,omitempty"`
+	Key              []string `protobuf:"bytes,2,rep,name=key" json:"key,omitempty"`
+	AcceptEncoding   *string  `protobuf:"bytes,3,opt,name=accept_encoding" json:"accept_encoding,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *GetMultiRequest) Reset()         { *m = GetMultiRequest{} }
+func (m *GetMultiRequest) String() string { return proto.CompactTextString(m) }
+func (*GetMultiRequest) ProtoMessage()    {}
+
+func (m *GetMultiRequest) GetGroup() string {
+	if m != nil && m.Group != nil {
+		return *m.Group
+	}
+	return ""
+}
+
+func (m *GetMultiRequest) GetKey() []string {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *GetMultiRequest) GetAcceptEncoding() string {
+	if m != nil && m.AcceptEncoding != nil {
+		return *m.AcceptEncoding
+	}
+	return ""
+}
+
+type GetMultiResponse struct {
+	Response         []*GetResponse `protobuf:"bytes,1,rep,name=response" json:"response,omitempty"`
+	Failed           []bool         `protobuf:"varint,2,rep,name=failed" json:"failed,omitempty"`
+	XXX_unrecognized []byte         `json:"-"`
+}
+
+func (m *GetMultiResponse) Reset()         { *m = GetMultiResponse{} }
+func (m *GetMultiResponse) String() string { return proto.CompactTextString(m) }
+func (*GetMultiResponse) ProtoMessage()    {}
+
+func (m *GetMultiResponse) GetResponse() []*GetResponse {
+	if m != nil {
+		return m.Response
+	}
+	return nil
+}
+
+func (m *GetMultiResponse) GetFailed() []bool {
+	if m != nil {
+		return m.Failed
+	}
+	return nil
+}
+
+type RemoveRequest struct {
+	Group            *string `protobuf:"bytes,1,req,name=group" json:"group,omitempty"`
+	Key              *string `protobuf:"bytes,2,req,name=key" json:"key,omitempty"`
+	FromOwner        *bool   `protobuf:"varint,3,opt,name=from_owner" json:"from_owner,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *RemoveRequest) Reset()         { *m = RemoveRequest{} }
+func (m *RemoveRequest) String() string { return proto.CompactTextString(m) }
+func (*RemoveRequest) ProtoMessage()    {}
+
+func (m *RemoveRequest) GetGroup() string {
+	if m != nil && m.Group != nil {
+		return *m.Group
+	}
+	return ""
+}
+
+func (m *RemoveRequest) GetKey() string {
+	if m != nil && m.Key != nil {
+		return *m.Key
+	}
+	return ""
+}
+
+func (m *RemoveRequest) GetFromOwner() bool {
+	if m != nil && m.FromOwner != nil {
+		return *m.FromOwner
+	}
+	return false
+}
+
+type RemoveResponse struct {
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *RemoveResponse) Reset()         { *m = RemoveResponse{} }
+func (m *RemoveResponse) String() string { return proto.CompactTextString(m) }
+func (*RemoveResponse) ProtoMessage()    {}
+
+type SetRequest struct {
+	Group            *string `protobuf:"bytes,1,req,name=group" json:"group,omitempty"`
+	Key              *string `protobuf:"bytes,2,req,name=key" json:"key,omitempty"`
+	Value            []byte  `protobuf:"bytes,3,opt,name=value" json:"value,omitempty"`
+	Expire           *int64  `protobuf:"varint,4,opt,name=expire" json:"expire,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *SetRequest) Reset()         { *m = SetRequest{} }
+func (m *SetRequest) String() string { return proto.CompactTextString(m) }
+func (*SetRequest) ProtoMessage()    {}
+
+func (m *SetRequest) GetGroup() string {
+	if m != nil && m.Group != nil {
+		return *m.Group
+	}
+	return ""
+}
+
+func (m *SetRequest) GetKey() string {
+	if m != nil && m.Key != nil {
+		return *m.Key
+	}
+	return ""
+}
+
+func (m *SetRequest) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *SetRequest) GetExpire() int64 {
+	if m != nil && m.Expire != nil {
+		return *m.Expire
+	}
+	return 0
+}
+
+type SetResponse struct {
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *SetResponse) Reset()         { *m = SetResponse{} }
+func (m *SetResponse) String() string { return proto.CompactTextString(m) }
+func (*SetResponse) ProtoMessage()    {}
+
 func init() {
 }
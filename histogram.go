@@ -0,0 +1,183 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram buckets are spaced exponentially, with histSub buckets
+// between successive powers of two, so a value's bucket bounds it
+// to within 1/histSub of itself.
+const (
+	histSubBits = 2
+	histSub     = 1 << histSubBits
+	histBuckets = (64 - histSubBits) << histSubBits
+)
+
+// A Histogram is a distribution of non-negative values, such as
+// latencies in nanoseconds or sizes in bytes. It is safe for
+// concurrent use without locking, and its zero value is empty.
+type Histogram struct {
+	count   int64
+	sum     int64
+	max     int64
+	lowest  int64 // math.MaxInt64 minus the smallest value
+	buckets [histBuckets]int64
+}
+
+// Observe atomically adds v to h. Negative values count as zero.
+func (h *Histogram) Observe(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	atomic.AddInt64(&h.buckets[histBucket(v)], 1)
+	atomic.AddInt64(&h.sum, v)
+	atomic.AddInt64(&h.count, 1)
+	storeMax(&h.max, v)
+	storeMax(&h.lowest, math.MaxInt64-v)
+}
+
+// storeMax atomically sets *addr to v if v is larger.
+func storeMax(addr *int64, v int64) {
+	for {
+		old := atomic.LoadInt64(addr)
+		if v <= old || atomic.CompareAndSwapInt64(addr, old, v) {
+			return
+		}
+	}
+}
+
+// observeSince adds the nanoseconds since start to h.
+func (h *Histogram) observeSince(start time.Time) {
+	h.Observe(int64(time.Since(start)))
+}
+
+// Snapshot returns the distribution of the values observed so far.
+// Values observed while Snapshot runs may be partly counted: in
+// Count and Sum but not Buckets, say.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Count: atomic.LoadInt64(&h.count),
+		Sum:   atomic.LoadInt64(&h.sum),
+		Max:   atomic.LoadInt64(&h.max),
+	}
+	if s.Count > 0 {
+		s.Min = math.MaxInt64 - atomic.LoadInt64(&h.lowest)
+	}
+	for i := range h.buckets {
+		if n := atomic.LoadInt64(&h.buckets[i]); n > 0 {
+			lo, hi := histBounds(i)
+			s.Buckets = append(s.Buckets, HistogramBucket{Lower: lo, Upper: hi, Count: n})
+		}
+	}
+	return s
+}
+
+// histBucket returns the index of the bucket holding v >= 0. Values
+// below 2*histSub have a bucket each.
+func histBucket(v int64) int {
+	if v < histSub {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - 1 - histSubBits
+	return (shift+1)<<histSubBits | int(v>>uint(shift))&(histSub-1)
+}
+
+// histBounds returns the range [lo, hi) of values in bucket i.
+func histBounds(i int) (lo, hi int64) {
+	e, m := i>>histSubBits, int64(i&(histSub-1))
+	if e == 0 {
+		return m, m + 1
+	}
+	lo = (histSub | m) << uint(e-1)
+	hi = lo + 1<<uint(e-1)
+	if hi < lo { // the last bucket ends at the largest int64
+		hi = 1<<63 - 1
+	}
+	return lo, hi
+}
+
+// HistogramSnapshot is the distribution of the values observed by a
+// Histogram.
+type HistogramSnapshot struct {
+	Count    int64             // values observed
+	Sum      int64             // total of the values observed
+	Min, Max int64             // smallest and largest values observed
+	Buckets  []HistogramBucket // the non-empty buckets, in increasing order
+}
+
+// A HistogramBucket counts the values observed in [Lower, Upper).
+type HistogramBucket struct {
+	Lower, Upper int64
+	Count        int64
+}
+
+// Mean returns the mean of the values observed, or 0 if there are
+// none.
+func (s HistogramSnapshot) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Sum) / float64(s.Count)
+}
+
+// Percentile estimates the value below which p percent of the
+// observed values fall, interpolating linearly within a bucket and
+// limited to the range of observed values. It returns 0 if there are
+// no values.
+func (s HistogramSnapshot) Percentile(p float64) int64 {
+	var total int64
+	for _, b := range s.Buckets {
+		total += b.Count
+	}
+	if total == 0 {
+		return 0
+	}
+	if p < 0 {
+		p = 0
+	} else if p > 100 {
+		p = 100
+	}
+	rank := p / 100 * float64(total)
+	var seen float64
+	for _, b := range s.Buckets {
+		n := float64(b.Count)
+		if seen+n >= rank {
+			return s.clamp(b.Lower + int64(float64(b.Upper-b.Lower)*(rank-seen)/n))
+		}
+		seen += n
+	}
+	return s.Max
+}
+
+// clamp limits v to the range of observed values, if known.
+func (s HistogramSnapshot) clamp(v int64) int64 {
+	if s.Count == 0 || s.Max < s.Min {
+		return v
+	}
+	if v < s.Min {
+		return s.Min
+	}
+	if v > s.Max {
+		return s.Max
+	}
+	return v
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	for v := int64(0); v < 1<<12; v++ {
+		lo, hi := histBounds(histBucket(v))
+		if v < lo || v >= hi {
+			t.Fatalf("value %d in bucket %d of [%d, %d)", v, histBucket(v), lo, hi)
+		}
+		if hi-lo > 1 && float64(hi-lo)/float64(lo) > 1.0/histSub {
+			t.Fatalf("bucket [%d, %d) is too wide", lo, hi)
+		}
+	}
+	if b := histBucket(1<<63 - 1); b != histBuckets-1 {
+		t.Errorf("largest value in bucket %d; want %d", b, histBuckets-1)
+	}
+
+	var h Histogram
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := int64(1); v <= 1000; v++ {
+				h.Observe(v)
+			}
+		}()
+	}
+	wg.Wait()
+	s := h.Snapshot()
+	if s.Count != 4000 || s.Sum != 4*500500 {
+		t.Errorf("Count, Sum = %d, %d; want 4000, %d", s.Count, s.Sum, 4*500500)
+	}
+	if s.Min != 1 || s.Max != 1000 {
+		t.Errorf("Min, Max = %d, %d; want 1, 1000", s.Min, s.Max)
+	}
+	if m := s.Mean(); m != 500.5 {
+		t.Errorf("Mean = %v; want 500.5", m)
+	}
+	for _, tt := range []struct {
+		p    float64
+		want int64
+	}{{0, 1}, {50, 500}, {90, 900}, {99, 990}, {100, 1000}} {
+		got := s.Percentile(tt.p)
+		if d := float64(got-tt.want) / float64(tt.want); d < -1.0/histSub || d > 1.0/histSub {
+			t.Errorf("Percentile(%v) = %d; want about %d", tt.p, got, tt.want)
+		}
+	}
+	var one Histogram
+	one.Observe(5000)
+	if p := one.Snapshot().Percentile(50); p != 5000 {
+		t.Errorf("Percentile of a single value = %d; want 5000", p)
+	}
+	if p := (HistogramSnapshot{}).Percentile(50); p != 0 {
+		t.Errorf("empty Percentile = %d; want 0", p)
+	}
+}
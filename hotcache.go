@@ -0,0 +1,92 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import "math/rand"
+
+// A HotCachePolicy decides which values fetched from peers a Group
+// mirrors in its hot cache, and how the group's cache bytes are
+// split between its main and hot caches.
+//
+// A HotCachePolicy must be safe for concurrent use.
+type HotCachePolicy interface {
+	// Admit reports whether value, fetched from the owner of key,
+	// should be added to the hot cache. qps is the key's request
+	// rate as reported by the owner, or negative if the owner
+	// didn't report one.
+	Admit(key string, value ByteView, qps float64) bool
+
+	// Victim returns the cache, MainCache or HotCache, to evict
+	// an item from when the group's caches, holding mainBytes and
+	// hotBytes respectively, are over budget.
+	Victim(mainBytes, hotBytes int64) CacheType
+}
+
+// DefaultHotCachePolicy is the HotCachePolicy used by a Group whose
+// HotCachePolicy is nil. It admits values whose owners report a
+// request rate of at least QPS or, if QPS is zero or the owner
+// doesn't report rates, a random FillProbability of values. It keeps
+// the hot cache to about HotRatio times the size of the main cache.
+type DefaultHotCachePolicy struct {
+	QPS             float64
+	FillProbability float64 // if zero, 1/10
+	HotRatio        float64 // if zero, 1/8
+}
+
+func (p DefaultHotCachePolicy) Admit(key string, value ByteView, qps float64) bool {
+	if p.QPS > 0 && qps >= 0 {
+		return qps >= p.QPS
+	}
+	if p.FillProbability == 0 {
+		return rand.Intn(10) == 0
+	}
+	return rand.Float64() < p.FillProbability
+}
+
+func (p DefaultHotCachePolicy) Victim(mainBytes, hotBytes int64) CacheType {
+	// TODO(bradfitz): this is good-enough-for-now logic.
+	// It should be something based on measurements and/or
+	// respecting the costs of different resources.
+	limit := mainBytes / 8
+	if p.HotRatio > 0 {
+		limit = int64(p.HotRatio * float64(mainBytes))
+	}
+	if hotBytes > limit {
+		return HotCache
+	}
+	return MainCache
+}
+
+// FixedHotCachePolicy is a deterministic HotCachePolicy, useful in
+// tests. It admits either every value or none, and evicts from the
+// hot cache while it holds more than HotRatio times the bytes of the
+// main cache.
+type FixedHotCachePolicy struct {
+	Mirror   bool    // whether to admit values
+	HotRatio float64 // hot cache size relative to the main cache
+}
+
+func (p FixedHotCachePolicy) Admit(key string, value ByteView, qps float64) bool {
+	return p.Mirror
+}
+
+func (p FixedHotCachePolicy) Victim(mainBytes, hotBytes int64) CacheType {
+	if float64(hotBytes) > p.HotRatio*float64(mainBytes) {
+		return HotCache
+	}
+	return MainCache
+}
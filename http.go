@@ -17,12 +17,16 @@ limitations under the License.
 package groupcache
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"code.google.com/p/goprotobuf/proto"
 	"github.com/golang/groupcache/consistenthash"
@@ -35,11 +39,29 @@ const defaultBasePath = "/_groupcache/"
 // TODO: make this configurable as well.
 const defaultReplicas = 3
 
+// acceptEncodingHeader carries a GetRequest's accept_encoding.
+const acceptEncodingHeader = "X-Groupcache-Accept-Encoding"
+
+// fromOwnerHeader carries a RemoveRequest's from_owner.
+const fromOwnerHeader = "X-Groupcache-From-Owner"
+
+// Requests other than Gets are sent under a reserved first path
+// element, starting with rpcMark, before the group and key. Servers
+// that predate a request see an unknown group and answer 404, rather
+// than serving it as a Get. No escaped group name starts with
+// rpcMark.
+const (
+	rpcMark   = "!"
+	removeRPC = rpcMark + "remove"
+	setRPC    = rpcMark + "set"
+)
+
 // HTTPPool implements PeerPicker for a pool of HTTP peers.
 type HTTPPool struct {
 	// Context optionally specifies a context for the server to use when it
 	// receives a request.
-	// If nil, the server uses a nil Context.
+	// If nil, or if it returns nil, the server uses the request's
+	// context.
 	Context func(*http.Request) Context
 
 	// Transport optionally specifies an http.RoundTripper for the client
@@ -47,34 +69,67 @@ type HTTPPool struct {
 	// If nil, the client uses http.DefaultTransport.
 	Transport func(Context) http.RoundTripper
 
+	// BreakerThreshold, if positive, is how many consecutive
+	// requests to a peer must fail for its circuit to open. While
+	// a peer's circuit is open the pool doesn't pick it: keys it
+	// owns go to the next peer on the ring or, if that is the
+	// current peer, are loaded locally. Requests fail if the peer
+	// can't be reached, reports that it is unavailable, or takes
+	// longer than BreakerLatency to answer.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long a peer's circuit stays open
+	// before a single probe request is let through to test it. If
+	// the probe succeeds the circuit closes, otherwise it stays
+	// open for another cooldown.
+	// If zero, the cooldown is 10 seconds.
+	BreakerCooldown time.Duration
+
+	// BreakerLatency, if positive, is how long a peer may take to
+	// answer a request before the request counts as failed.
+	BreakerLatency time.Duration
+
 	// base path including leading and trailing slash, e.g. "/_groupcache/"
 	basePath string
 
 	// this peer's base URL, e.g. "https://example.net:8000"
 	self string
 
-	mu    sync.Mutex
-	peers *consistenthash.Map
-}
+	// registry holds the groups served by the pool.
+	registry *Registry
 
-var httpPoolMade bool
+	mu          sync.Mutex
+	peers       *consistenthash.Map
+	httpGetters map[string]*httpGetter // keyed by e.g. "http://10.0.0.2:8008"
+}
 
 // NewHTTPPool initializes an HTTP pool of peers.
-// It registers itself as a PeerPicker and as an HTTP handler with the
-// http.DefaultServeMux.
+// It registers itself as a PeerPicker with DefaultRegistry and as an
+// HTTP handler with the http.DefaultServeMux.
 // The self argument be a valid base URL that points to the current server,
 // for example "http://example.net:8000".
 func NewHTTPPool(self string) *HTTPPool {
-	if httpPoolMade {
-		panic("groupcache: NewHTTPPool must be called only once")
-	}
-	httpPoolMade = true
-	p := &HTTPPool{basePath: defaultBasePath, self: self, peers: consistenthash.New(defaultReplicas, nil)}
-	RegisterPeerPicker(func() PeerPicker { return p })
+	p := DefaultRegistry.NewHTTPPool(self)
 	http.Handle(defaultBasePath, p)
 	return p
 }
 
+// NewHTTPPool initializes an HTTP pool of peers serving the groups
+// in r. It registers itself as r's PeerPicker. Unlike the
+// package-level NewHTTPPool, it does not register an HTTP handler;
+// the caller must arrange for the pool to serve requests under
+// "/_groupcache/".
+func (r *Registry) NewHTTPPool(self string) *HTTPPool {
+	p := &HTTPPool{
+		basePath: defaultBasePath,
+		self:     self,
+		registry: r,
+		peers:    consistenthash.New(defaultReplicas, nil),
+	}
+	r.RegisterPeerPicker(func() PeerPicker { return p })
+	return p
+}
+
 // Set updates the pool's list of peers.
 // Each peer value should be a valid base URL,
 // for example "http://example.net:8000".
@@ -83,28 +138,152 @@ func (p *HTTPPool) Set(peers ...string) {
 	defer p.mu.Unlock()
 	p.peers = consistenthash.New(defaultReplicas, nil)
 	p.peers.Add(peers...)
+	old := p.httpGetters
+	p.httpGetters = make(map[string]*httpGetter, len(peers))
+	for _, peer := range peers {
+		if h, ok := old[peer]; ok {
+			// Keep the peer's circuit breaker.
+			p.httpGetters[peer] = h
+			continue
+		}
+		p.httpGetters[peer] = &httpGetter{pool: p, baseURL: peer + p.basePath}
+	}
 }
 
-func (p *HTTPPool) PickPeer(key string) (ProtoGetter, bool) {
+// PickOwner returns the owner of key on the pool's hash ring, even
+// if its circuit is open, and false if the current peer owns it.
+func (p *HTTPPool) PickOwner(key string) (ProtoGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	if p.peers.IsEmpty() {
 		return nil, false
 	}
 	if peer := p.peers.Get(key); peer != p.self {
-		// TODO: pre-build a slice of *httpGetter when Set()
-		// is called to avoid these two allocations.
-		return &httpGetter{p.Transport, peer + p.basePath}, true
+		return p.httpGetters[peer], true
 	}
 	return nil, false
 }
 
+func (p *HTTPPool) PickPeer(key string) (ProtoGetter, bool) {
+	peers, _ := p.PickPeers(key, 1)
+	if len(peers) == 0 {
+		return nil, false
+	}
+	return peers[0], true
+}
+
+// PickPeers returns the first n owners of key on the pool's hash
+// ring, up to but not including the current peer. Peers whose
+// circuits are open are skipped.
+func (p *HTTPPool) PickPeers(key string, n int) ([]ProtoGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers.IsEmpty() {
+		return nil, true
+	}
+	owners := p.peers.GetN(key, n)
+	if p.BreakerThreshold > 0 {
+		// Look past open circuits all the way around the ring.
+		owners = p.peers.GetN(key, len(p.httpGetters))
+	}
+	now := time.Now()
+	var peers []ProtoGetter
+	for _, peer := range owners {
+		if len(peers) == n {
+			break
+		}
+		if peer == p.self {
+			return peers, true
+		}
+		h := p.httpGetters[peer]
+		if p.BreakerThreshold > 0 && !h.breaker.allow(now, p.cooldown()) {
+			continue
+		}
+		peers = append(peers, h)
+	}
+	return peers, false
+}
+
+func (p *HTTPPool) cooldown() time.Duration {
+	if p.BreakerCooldown > 0 {
+		return p.BreakerCooldown
+	}
+	return defaultBreakerCooldown
+}
+
+// PeerStatus returns the health of each peer in the pool other than
+// the current one, as tracked by its circuit breaker.
+func (p *HTTPPool) PeerStatus() []PeerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var status []PeerStatus
+	for peer, h := range p.httpGetters {
+		if peer != p.self {
+			status = append(status, h.breaker.status(peer))
+		}
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].URL < status[j].URL })
+	return status
+}
+
+// PeerStats returns the metrics of the pool's requests to each peer
+// other than the current one.
+func (p *HTTPPool) PeerStats() []PeerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var stats []PeerStats
+	for peer, h := range p.httpGetters {
+		if peer != p.self {
+			stats = append(stats, h.stats.snapshot(peer))
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].URL < stats[j].URL })
+	return stats
+}
+
+// Peers returns the base URLs of the peers in the pool, including
+// the current one, sorted.
+func (p *HTTPPool) Peers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]string, 0, len(p.httpGetters))
+	for peer := range p.httpGetters {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// ListPeers returns a ProtoGetter for each peer in the pool other
+// than the current one.
+func (p *HTTPPool) ListPeers() []ProtoGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]ProtoGetter, 0, len(p.httpGetters))
+	for peer, h := range p.httpGetters {
+		if peer != p.self {
+			peers = append(peers, h)
+		}
+	}
+	return peers
+}
+
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Parse request.
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
 	}
-	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
+	path := r.URL.Path[len(p.basePath):]
+	var rpc string
+	if strings.HasPrefix(path, rpcMark) {
+		i := strings.Index(path, "/")
+		if i < 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		rpc, path = path[:i], path[i+1:]
+	}
+	parts := strings.SplitN(path, "/", 2)
 	if len(parts) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
@@ -121,26 +300,160 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch the value for this group/key.
-	group := GetGroup(groupName)
+	group := p.registry.GetGroup(groupName)
 	if group == nil {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
-	var ctx Context
+	ctx := r.Context()
 	if p.Context != nil {
-		ctx = p.Context(r)
+		// Older Context funcs may return nil, meaning none.
+		if c := p.Context(r); c != nil {
+			ctx = c
+		}
+	}
+	ctx = extractSpanContext(ctx, r)
+
+	switch {
+	case rpc == removeRPC && r.Method == "DELETE":
+		serveRemove(ctx, w, group, key, r.Header.Get(fromOwnerHeader) != "")
+	case rpc == setRPC && r.Method == "PUT":
+		serveSet(ctx, w, r, group, key)
+	case rpc != "":
+		http.Error(w, "unknown request: "+rpc, http.StatusNotFound)
+	case r.Method == "POST":
+		serveGetMulti(ctx, w, r, group)
+	case r.Method == "DELETE" || r.Method == "PUT":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		serveGet(ctx, w, group, key, r.Header.Get(acceptEncodingHeader))
 	}
+}
 
+func serveGet(ctx Context, w http.ResponseWriter, group *Group, key, accept string) {
 	group.Stats.ServerRequests.Add(1)
-	var value []byte
-	err = group.Get(ctx, key, AllocatingByteSliceSink(&value))
+	var value ByteView
+	err := group.Get(ctx, key, encodedViewSink(&value))
+	if err != nil {
+		res := group.errorResponse(err)
+		if res == nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeProto(w, res)
+		return
+	}
+	res, err := group.valueResponse(value, accept)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	res.MinuteQps = proto.Float64(group.keyRates.observe(key, time.Now()))
+	writeProto(w, res)
+}
+
+func serveGetMulti(ctx Context, w http.ResponseWriter, r *http.Request, group *Group) {
+	in := &pb.GetMultiRequest{}
+	if !readProto(w, r, in) {
+		return
+	}
+	keys := in.GetKey()
+	group.Stats.ServerRequests.Add(int64(len(keys)))
+	values := make([]ByteView, len(keys))
+	dests := make([]Sink, len(keys))
+	for i := range values {
+		dests[i] = encodedViewSink(&values[i])
+	}
+	err := group.GetMulti(ctx, keys, dests)
+	errs, _ := err.(MultiError)
+	if err != nil && errs == nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := &pb.GetMultiResponse{Response: make([]*pb.GetResponse, len(keys))}
+	now := time.Now()
+	for i, value := range values {
+		if errs == nil || errs[i] == nil {
+			if out.Response[i], err = group.valueResponse(value, in.GetAcceptEncoding()); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out.Response[i].MinuteQps = proto.Float64(group.keyRates.observe(keys[i], now))
+			continue
+		}
+		if out.Response[i] = group.errorResponse(errs[i]); out.Response[i] == nil {
+			if out.Failed == nil {
+				out.Failed = make([]bool, len(keys))
+			}
+			out.Response[i] = &pb.GetResponse{}
+			out.Failed[i] = true
+		}
+	}
+	writeProto(w, out)
+}
+
+func serveRemove(ctx Context, w http.ResponseWriter, group *Group, key string, fromOwner bool) {
+	if err := group.removeForPeer(ctx, key, fromOwner); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeProto(w, &pb.RemoveResponse{})
+}
 
-	// Write the value to the response body as a proto message.
-	body, err := proto.Marshal(&pb.GetResponse{Value: value})
+func serveSet(ctx Context, w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	in := &pb.SetRequest{}
+	if !readProto(w, r, in) {
+		return
+	}
+	value := ByteView{b: in.Value}
+	if in.Expire != nil {
+		value.e = time.Unix(0, in.GetExpire())
+	}
+	if err := group.setForPeer(ctx, key, value); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeProto(w, &pb.SetResponse{})
+}
+
+// valueResponse returns the response carrying value, as cached, to a
+// peer. The value is sent encoded if it is cached encoded and the
+// peer accepts the encoding, and is otherwise decoded.
+func (g *Group) valueResponse(value ByteView, accept string) (*pb.GetResponse, error) {
+	if value.z == nil || value.z.Name() != accept {
+		var err error
+		if value, err = value.decode(); err != nil {
+			return nil, err
+		}
+	}
+	res := &pb.GetResponse{Value: value.ByteSlice()}
+	if value.z != nil {
+		res.Encoding = proto.String(value.z.Name())
+	}
+	if e := value.Expire(); !e.IsZero() {
+		res.Expire = proto.Int64(e.UnixNano())
+	}
+	return res, nil
+}
+
+// readProto decodes the body of r into m. On failure it replies to
+// the request with an error and returns false.
+func readProto(w http.ResponseWriter, r *http.Request, m proto.Message) bool {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "reading body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err := proto.Unmarshal(b, m); err != nil {
+		http.Error(w, "decoding body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeProto writes m to the response body as a proto message.
+func writeProto(w http.ResponseWriter, m proto.Message) {
+	body, err := proto.Marshal(m)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -150,41 +463,123 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 type httpGetter struct {
-	transport func(Context) http.RoundTripper
-	baseURL   string
-}
-
-func (h *httpGetter) Get(context Context, in *pb.GetRequest, out *pb.GetResponse) error {
-	u := fmt.Sprintf(
-		"%v%v/%v",
-		h.baseURL,
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
-	)
-	req, err := http.NewRequest("GET", u, nil)
+	pool    *HTTPPool
+	baseURL string
+	breaker breaker
+	stats   peerStats
+}
+
+// String returns the peer's base URL.
+func (h *httpGetter) String() string {
+	return strings.TrimSuffix(h.baseURL, h.pool.basePath)
+}
+
+func (h *httpGetter) Get(ctx Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	// A GET has no body, so the accepted encoding goes in a header.
+	var header http.Header
+	if e := in.GetAcceptEncoding(); e != "" {
+		header = http.Header{acceptEncodingHeader: {e}}
+	}
+	return h.roundTrip(ctx, "GET", rpcPath("", in.GetGroup(), in.GetKey()), header, nil, out)
+}
+
+func (h *httpGetter) GetMulti(ctx Context, in *pb.GetMultiRequest, out *pb.GetMultiResponse) error {
+	return h.roundTrip(ctx, "POST", rpcPath("", in.GetGroup(), ""), nil, in, out)
+}
+
+func (h *httpGetter) Remove(ctx Context, in *pb.RemoveRequest, out *pb.RemoveResponse) error {
+	// A DELETE has no body, so from_owner goes in a header.
+	var header http.Header
+	if in.GetFromOwner() {
+		header = http.Header{fromOwnerHeader: {"1"}}
+	}
+	return h.roundTrip(ctx, "DELETE", rpcPath(removeRPC, in.GetGroup(), in.GetKey()), header, nil, out)
+}
+
+func (h *httpGetter) Set(ctx Context, in *pb.SetRequest, out *pb.SetResponse) error {
+	return h.roundTrip(ctx, "PUT", rpcPath(setRPC, in.GetGroup(), in.GetKey()), nil, in, out)
+}
+
+// rpcPath returns the path, relative to a peer's base URL, of a
+// request for the group and key, under the reserved element rpc if
+// it isn't empty.
+func rpcPath(rpc, group, key string) string {
+	path := url.QueryEscape(group) + "/" + url.QueryEscape(key)
+	if rpc != "" {
+		path = rpc + "/" + path
+	}
+	return path
+}
+
+// roundTrip sends a request for path to the peer, with the given
+// header and body encoded as the request body if non-nil, and
+// decodes the response body into out.
+func (h *httpGetter) roundTrip(ctx Context, method, path string, header http.Header, body, out proto.Message) error {
+	u := h.baseURL + path
+	var rb io.Reader
+	if body != nil {
+		b, err := proto.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rb = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rb)
 	if err != nil {
 		return err
 	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	injectSpanContext(req)
 	tr := http.DefaultTransport
-	if h.transport != nil {
-		tr = h.transport(context)
+	if h.pool.Transport != nil {
+		tr = h.pool.Transport(ctx)
 	}
+	start := time.Now()
+	healthy := false
+	class := errOther
+	var n int
+	defer func() {
+		d := time.Since(start)
+		h.stats.record(d, n, class)
+		if !healthy && ctx.Err() != nil {
+			h.breaker.abandon()
+			return
+		}
+		if h.pool.BreakerLatency > 0 && d > h.pool.BreakerLatency {
+			healthy = false
+		}
+		h.breaker.record(healthy, d, time.Now(), h.pool.BreakerThreshold)
+	}()
 	res, err := tr.RoundTrip(req)
 	if err != nil {
+		class = transportErrClass(err)
 		return err
 	}
 	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
-	}
 	// TODO: avoid this garbage.
 	b, err := ioutil.ReadAll(res.Body)
+	n = len(b)
 	if err != nil {
+		class = transportErrClass(err)
 		return fmt.Errorf("reading response body: %v", err)
 	}
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+	default:
+		// The peer is up, even if it couldn't serve the request.
+		healthy = true
+	}
+	if res.StatusCode != http.StatusOK {
+		class = errBadStatus
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
 	err = proto.Unmarshal(b, out)
 	if err != nil {
+		class = errDecode
 		return fmt.Errorf("decoding response body: %v", err)
 	}
+	class = errNone
 	return nil
 }
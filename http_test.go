@@ -17,11 +17,15 @@ limitations under the License.
 package groupcache
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"flag"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"strconv"
@@ -29,6 +33,9 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+	pb "github.com/golang/groupcache/groupcachepb"
 )
 
 var (
@@ -44,8 +51,10 @@ func TestHTTPPool(t *testing.T) {
 	}
 
 	const (
-		nChild = 4
-		nGets  = 100
+		nChild   = 4
+		nGets    = 100
+		nSets    = 10
+		nRemoves = 10
 	)
 
 	var childAddr []string
@@ -100,6 +109,292 @@ func TestHTTPPool(t *testing.T) {
 		}
 		t.Logf("Get key=%q, value=%q (peer:key)", key, value)
 	}
+
+	keys := testKeys(nGets)
+	values := make([]string, len(keys))
+	dests := make([]Sink, len(keys))
+	for i := range dests {
+		dests[i] = StringSink(&values[i])
+	}
+	if err := g.GetMulti(context.Background(), keys, dests); err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		if suffix := ":" + key; !strings.HasSuffix(values[i], suffix) {
+			t.Errorf("GetMulti value for %q = %q, want value ending in %q", key, values[i], suffix)
+		}
+	}
+
+	for _, key := range testKeys(nSets) {
+		want := "set:" + key
+		if err := g.Set(context.Background(), key, []byte(want), time.Time{}, false); err != nil {
+			t.Fatalf("Set(%q) = %v", key, err)
+		}
+		var value string
+		if err := g.Get(context.Background(), key, StringSink(&value)); err != nil {
+			t.Fatal(err)
+		}
+		if value != want {
+			t.Errorf("Get(%q) after Set = %q, want %q", key, value, want)
+		}
+	}
+
+	for _, key := range testKeys(nRemoves) {
+		if err := g.Remove(context.Background(), key); err != nil {
+			t.Errorf("Remove(%q) = %v", key, err)
+		}
+	}
+}
+
+func TestHTTPPoolPickPeers(t *testing.T) {
+	peers := []string{"http://a", "http://b", "http://c"}
+	p := NewRegistry().NewHTTPPool("http://b")
+	p.Set(peers...)
+	for _, key := range testKeys(100) {
+		got, isOwner := p.PickPeers(key, len(peers))
+		owner, ok := p.PickPeer(key)
+		if ok != (len(got) > 0) || ok && got[0] != owner {
+			t.Fatalf("PickPeers(%q)[0] disagrees with PickPeer", key)
+		}
+		if !isOwner {
+			t.Fatalf("PickPeers(%q, %d) = _, false; want true", key, len(peers))
+		}
+		for _, h := range got {
+			if h.(*httpGetter).baseURL == "http://b"+defaultBasePath {
+				t.Fatalf("PickPeers(%q) includes self", key)
+			}
+		}
+	}
+}
+
+// flakyTransport fails requests to hosts marked down and answers
+// all others with an empty value.
+type flakyTransport struct {
+	mu   sync.Mutex
+	down map[string]bool
+}
+
+func (t *flakyTransport) setDown(host string, down bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.down[host] = down
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.down[req.URL.Host] {
+		return nil, errors.New("connection refused")
+	}
+	body, _ := proto.Marshal(&pb.GetResponse{Value: []byte("x")})
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+	}, nil
+}
+
+func TestHTTPPoolBreaker(t *testing.T) {
+	tr := &flakyTransport{down: map[string]bool{"a": true}}
+	p := NewRegistry().NewHTTPPool("http://b")
+	p.Transport = func(Context) http.RoundTripper { return tr }
+	p.BreakerThreshold = 2
+	p.BreakerCooldown = 50 * time.Millisecond
+	p.Set("http://a", "http://b", "http://c")
+
+	pickedA := func(key string) bool {
+		peer, ok := p.PickPeer(key)
+		return ok && peer.(*httpGetter).baseURL == "http://a"+defaultBasePath
+	}
+	var key string
+	for _, k := range testKeys(100) {
+		if pickedA(k) {
+			key = k
+			break
+		}
+	}
+	peer, _ := p.PickPeer(key)
+	get := func() error {
+		return peer.Get(context.Background(), &pb.GetRequest{Group: proto.String("g"), Key: &key}, &pb.GetResponse{})
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := get(); err == nil {
+			t.Fatal("Get from down peer succeeded")
+		}
+	}
+	if pickedA(key) {
+		t.Error("PickPeer picked a peer whose circuit is open")
+	}
+	if st := p.PeerStatus()[0]; st.State != CircuitOpen || st.Trips != 1 || st.ConsecutiveFailures != 2 {
+		t.Errorf("PeerStatus = %+v; want open after 1 trip and 2 failures", st)
+	}
+
+	tr.setDown("a", false)
+	time.Sleep(60 * time.Millisecond)
+	if !pickedA(key) {
+		t.Fatal("PickPeer didn't probe the peer after its cooldown")
+	}
+	if pickedA(key) {
+		t.Error("PickPeer picked a peer with a probe outstanding")
+	}
+	if err := get(); err != nil {
+		t.Fatal(err)
+	}
+	if st := p.PeerStatus()[0]; st.State != CircuitClosed || st.Requests != 3 || st.Failures != 2 {
+		t.Errorf("PeerStatus = %+v; want closed after 3 requests and 2 failures", st)
+	}
+	if !pickedA(key) {
+		t.Error("PickPeer skipped a peer whose circuit closed")
+	}
+}
+
+func TestHTTPPoolRemoveOpenCircuit(t *testing.T) {
+	tr := &flakyTransport{down: map[string]bool{"a": true}}
+	r := NewRegistry()
+	p := r.NewHTTPPool("http://b")
+	p.Transport = func(Context) http.RoundTripper { return tr }
+	p.BreakerThreshold = 1
+	p.BreakerCooldown = time.Hour
+	p.Set("http://a", "http://b", "http://c")
+	g := r.NewGroup("remove-open-circuit", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}))
+
+	var key string
+	var owner ProtoGetter
+	for _, k := range testKeys(100) {
+		if peer, ok := p.PickOwner(k); ok && peer.(*httpGetter).baseURL == "http://a"+defaultBasePath {
+			key, owner = k, peer
+			break
+		}
+	}
+	ctx := context.Background()
+	owner.Get(ctx, &pb.GetRequest{Group: proto.String("g"), Key: &key}, &pb.GetResponse{})
+	if peer, ok := p.PickPeer(key); ok && peer == owner {
+		t.Fatal("PickPeer picked a peer whose circuit is open")
+	}
+	if peer, ok := p.PickOwner(key); !ok || peer != owner {
+		t.Fatal("PickOwner skipped a peer whose circuit is open")
+	}
+
+	if err := g.Remove(ctx, key); err == nil {
+		t.Error("Remove succeeded with the key's owner unreachable")
+	}
+	if err := g.Set(ctx, key, []byte("value"), time.Time{}, false); err == nil {
+		t.Error("Set succeeded with the key's owner unreachable")
+	}
+	tr.setDown("a", false)
+	if err := g.Remove(ctx, key); err != nil {
+		t.Errorf("Remove with the owner back up: %v", err)
+	}
+
+	// A removal sent to a peer that doesn't own the key fails, unless
+	// it is the owner invalidating copies.
+	if err := g.removeForPeer(ctx, key, false); err != errNotOwner {
+		t.Errorf("removeForPeer by a non-owner = %v; want errNotOwner", err)
+	}
+	if err := g.removeForPeer(ctx, key, true); err != nil {
+		t.Errorf("removeForPeer from the owner = %v", err)
+	}
+}
+
+// tests that requests unknown to a peer predating them fail, rather
+// than being served as Gets.
+func TestHTTPPoolOldPeer(t *testing.T) {
+	// The old server serves every request as a Get of the group and
+	// key in its path.
+	value, _ := proto.Marshal(&pb.GetResponse{Value: []byte("value")})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, defaultBasePath), "/", 2)
+		if parts[0] != "g" {
+			http.Error(w, "no such group: "+parts[0], http.StatusNotFound)
+			return
+		}
+		w.Write(value)
+	}))
+	defer srv.Close()
+	p := NewRegistry().NewHTTPPool("http://self")
+	p.Set(srv.URL)
+	h := p.httpGetters[srv.URL]
+
+	ctx := context.Background()
+	if err := h.Get(ctx, &pb.GetRequest{Group: proto.String("g"), Key: proto.String("k")}, &pb.GetResponse{}); err != nil {
+		t.Fatalf("Get from old peer: %v", err)
+	}
+	if err := h.Remove(ctx, &pb.RemoveRequest{Group: proto.String("g"), Key: proto.String("k")}, &pb.RemoveResponse{}); err == nil {
+		t.Error("Remove sent to an old peer succeeded")
+	}
+	if err := h.Set(ctx, &pb.SetRequest{Group: proto.String("g"), Key: proto.String("k"), Value: []byte("v")}, &pb.SetResponse{}); err == nil {
+		t.Error("Set sent to an old peer succeeded")
+	}
+}
+
+func TestHTTPPoolPeerStats(t *testing.T) {
+	value, _ := proto.Marshal(&pb.GetResponse{Value: []byte("value")})
+	handlers := map[string]http.HandlerFunc{
+		"ok": func(w http.ResponseWriter, r *http.Request) {
+			w.Write(value)
+		},
+		"status": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "oops", http.StatusInternalServerError)
+		},
+		"decode": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("not a proto"))
+		},
+		"slow": func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		},
+	}
+	p := NewRegistry().NewHTTPPool("http://self")
+	urls := map[string]string{}
+	var peers []string
+	for name, h := range handlers {
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+		urls[name] = srv.URL
+		peers = append(peers, srv.URL)
+	}
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refused.Close()
+	urls["refused"] = refused.URL
+	peers = append(peers, refused.URL)
+	p.Set(peers...)
+
+	get := func(name string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		h := p.httpGetters[urls[name]]
+		h.Get(ctx, &pb.GetRequest{Group: proto.String("g"), Key: proto.String("k")}, &pb.GetResponse{})
+	}
+	for _, name := range []string{"ok", "ok", "status", "decode", "slow", "refused"} {
+		get(name)
+	}
+
+	stats := map[string]PeerStats{}
+	for _, st := range p.PeerStats() {
+		stats[st.URL] = st
+	}
+	if len(stats) != len(urls) {
+		t.Fatalf("PeerStats has %d peers; want %d", len(stats), len(urls))
+	}
+	ok := stats[urls["ok"]]
+	if ok.Requests != 2 || ok.Latency.Count != 2 || ok.ResponseBytes.Sum != int64(2*len(value)) {
+		t.Errorf("ok peer stats = %+v; want 2 requests for %d bytes", ok, 2*len(value))
+	}
+	for name, count := range map[string]func(PeerStats) int64{
+		"status":  func(st PeerStats) int64 { return st.BadStatus },
+		"decode":  func(st PeerStats) int64 { return st.DecodeErrors },
+		"slow":    func(st PeerStats) int64 { return st.Timeouts },
+		"refused": func(st PeerStats) int64 { return st.ConnectionRefused },
+	} {
+		if st := stats[urls[name]]; st.Requests != 1 || count(st) != 1 {
+			t.Errorf("%s peer stats = %+v; want 1 request failed by %s", name, st, name)
+		}
+	}
+	if slow := stats[urls["slow"]]; slow.Latency.Percentile(50) < int64(50*time.Millisecond) {
+		t.Errorf("slow peer median latency = %v; want at least 50ms", time.Duration(slow.Latency.Percentile(50)))
+	}
 }
 
 func testKeys(n int) (keys []string) {
@@ -0,0 +1,152 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// metrics.go exports group statistics in the Prometheus text format.
+
+package groupcache
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// groupMetrics describes the counters in Stats.
+var groupMetrics = []struct {
+	name, help string
+	value      func(*Stats) *AtomicInt
+}{
+	{"gets", "Get requests, including from peers.", func(s *Stats) *AtomicInt { return &s.Gets }},
+	{"cache_hits", "Gets answered from either cache.", func(s *Stats) *AtomicInt { return &s.CacheHits }},
+	{"peer_loads", "Loads answered by a peer, including with an error.", func(s *Stats) *AtomicInt { return &s.PeerLoads }},
+	{"peer_errors", "Failed requests to peers.", func(s *Stats) *AtomicInt { return &s.PeerErrors }},
+	{"loads", "Gets not answered from cache.", func(s *Stats) *AtomicInt { return &s.Loads }},
+	{"loads_deduped", "Loads after duplicate suppression.", func(s *Stats) *AtomicInt { return &s.LoadsDeduped }},
+	{"local_loads", "Successful loads by the Getter.", func(s *Stats) *AtomicInt { return &s.LocalLoads }},
+	{"local_load_errors", "Failed loads by the Getter.", func(s *Stats) *AtomicInt { return &s.LocalLoadErrs }},
+	{"server_requests", "Gets that came over the network from peers.", func(s *Stats) *AtomicInt { return &s.ServerRequests }},
+	{"negative_hits", "Gets answered with a cached error.", func(s *Stats) *AtomicInt { return &s.NegativeHits }},
+	{"stale_hits", "Cache hits on expired values being refreshed.", func(s *Stats) *AtomicInt { return &s.StaleHits }},
+}
+
+// cacheMetrics describes the fields of CacheStats.
+var cacheMetrics = []struct {
+	name, help, typ string
+	value           func(*CacheStats) int64
+}{
+	{"cache_bytes", "Bytes of keys and values in the cache.", "gauge", func(s *CacheStats) int64 { return s.Bytes }},
+	{"cache_items", "Items in the cache.", "gauge", func(s *CacheStats) int64 { return s.Items }},
+	{"cache_gets", "Lookups in the cache.", "counter", func(s *CacheStats) int64 { return s.Gets }},
+	{"cache_lookup_hits", "Lookups that found a value.", "counter", func(s *CacheStats) int64 { return s.Hits }},
+	{"cache_evictions", "Items evicted or removed from the cache.", "counter", func(s *CacheStats) int64 { return s.Evictions }},
+}
+
+// histogramMetrics describes the Histograms, which are served as
+// summaries. Values are multiplied by scale, to give seconds rather
+// than nanoseconds.
+var histogramMetrics = []struct {
+	name, help string
+	scale      float64
+	value      func(*Histograms) *Histogram
+}{
+	{"local_load_seconds", "Latency of calls to the Getter.", 1e-9, func(h *Histograms) *Histogram { return &h.LocalLoad }},
+	{"peer_fetch_seconds", "Latency of requests to peers.", 1e-9, func(h *Histograms) *Histogram { return &h.PeerFetch }},
+	{"load_wait_seconds", "Time spent waiting for loads, led or shared.", 1e-9, func(h *Histograms) *Histogram { return &h.LoadWait }},
+	{"value_size_bytes", "Sizes of values loaded locally or from peers.", 1, func(h *Histograms) *Histogram { return &h.ValueSize }},
+}
+
+// summaryQuantiles are the quantiles served for each histogram.
+var summaryQuantiles = []float64{0.5, 0.9, 0.99}
+
+// MetricsHandler returns an http.Handler that serves the Stats,
+// CacheStats and Histograms of every group in DefaultRegistry, in
+// the Prometheus text exposition format.
+func MetricsHandler() http.Handler {
+	return DefaultRegistry.MetricsHandler()
+}
+
+// MetricsHandler returns an http.Handler that serves the Stats,
+// CacheStats and Histograms of every group in r, in the Prometheus
+// text exposition format. Metrics are named with the prefix "groupcache_" and
+// labeled with the group name and, for cache statistics, the cache
+// ("main", "hot" or, for groups with a DiskCache, "disk").
+func (r *Registry) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		r.writeMetrics(bw)
+		bw.Flush()
+	})
+}
+
+func (r *Registry) writeMetrics(w *bufio.Writer) {
+	groups := r.Groups()
+	for _, m := range groupMetrics {
+		name := "groupcache_" + m.name + "_total"
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, m.help, name)
+		for _, g := range groups {
+			fmt.Fprintf(w, "%s{group=%s} %d\n", name, labelValue(g.name), m.value(&g.Stats).Get())
+		}
+	}
+
+	type cacheStats struct {
+		group, cache string
+		stats        CacheStats
+	}
+	var caches []cacheStats
+	for _, g := range groups {
+		caches = append(caches,
+			cacheStats{g.name, "main", g.CacheStats(MainCache)},
+			cacheStats{g.name, "hot", g.CacheStats(HotCache)})
+		if g.DiskCache != nil {
+			caches = append(caches, cacheStats{g.name, "disk", g.CacheStats(DiskTier)})
+		}
+	}
+	for _, m := range cacheMetrics {
+		name := "groupcache_" + m.name
+		if m.typ == "counter" {
+			name += "_total"
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, m.help, name, m.typ)
+		for _, c := range caches {
+			fmt.Fprintf(w, "%s{group=%s,cache=%q} %d\n", name, labelValue(c.group), c.cache, m.value(&c.stats))
+		}
+	}
+
+	for _, m := range histogramMetrics {
+		name := "groupcache_" + m.name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n", name, m.help, name)
+		for _, g := range groups {
+			s := m.value(&g.Histograms).Snapshot()
+			group := labelValue(g.name)
+			for _, q := range summaryQuantiles {
+				v := float64(s.Percentile(q*100)) * m.scale
+				fmt.Fprintf(w, "%s{group=%s,quantile=\"%g\"} %g\n", name, group, q, v)
+			}
+			fmt.Fprintf(w, "%s_sum{group=%s} %g\n", name, group, float64(s.Sum)*m.scale)
+			fmt.Fprintf(w, "%s_count{group=%s} %d\n", name, group, s.Count)
+		}
+	}
+}
+
+// labelEscaper escapes a Prometheus label value.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue returns s quoted as a Prometheus label value.
+func labelValue(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	r := NewRegistry()
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	})
+	g := r.newGroup("metrics-test", 1<<20, getter, NoPeers{})
+	r.newGroup(`odd "name"`, 1<<20, getter, NoPeers{})
+	var s string
+	g.Get(dummyCtx, "key", StringSink(&s))
+	g.Get(dummyCtx, "key", StringSink(&s))
+
+	w := httptest.NewRecorder()
+	r.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE groupcache_gets_total counter\n",
+		`groupcache_gets_total{group="metrics-test"} 2` + "\n",
+		`groupcache_cache_hits_total{group="metrics-test"} 1` + "\n",
+		`groupcache_local_loads_total{group="metrics-test"} 1` + "\n",
+		`groupcache_gets_total{group="odd \"name\""} 0` + "\n",
+		"# TYPE groupcache_cache_items gauge\n",
+		`groupcache_cache_items{group="metrics-test",cache="main"} 1` + "\n",
+		`groupcache_cache_items{group="metrics-test",cache="hot"} 0` + "\n",
+		`groupcache_cache_lookup_hits_total{group="metrics-test",cache="main"} 1` + "\n",
+		"# TYPE groupcache_local_load_seconds summary\n",
+		`groupcache_local_load_seconds_count{group="metrics-test"} 1` + "\n",
+		`groupcache_value_size_bytes{group="metrics-test",quantile="0.99"} 5` + "\n",
+		`groupcache_value_size_bytes_sum{group="metrics-test"} 5` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q; got:\n%s", want, body)
+		}
+	}
+}
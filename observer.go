@@ -0,0 +1,103 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"fmt"
+	"time"
+)
+
+// An Observer is notified of events in a Group, for logging,
+// tracing or metrics. Its methods are called synchronously, some
+// with the group's cache locks held, so they must be quick, must not
+// call back into the group, and must be safe for concurrent use.
+//
+// Embed NopObserver to implement only some of the methods.
+type Observer interface {
+	// CacheHit is called when key is found in the main or hot
+	// cache or the disk tier.
+	CacheHit(key string, cache CacheType)
+
+	// PeerFetchStart is called when key is requested from a peer.
+	PeerFetchStart(key, peer string)
+
+	// PeerFetchDone is called when a request for key to a peer
+	// completes, d after it started. err is non-nil if the peer
+	// couldn't be reached or reported an error.
+	PeerFetchDone(key, peer string, d time.Duration, err error)
+
+	// LocalLoad is called when the group's Getter returns, having
+	// taken d to load key.
+	LocalLoad(key string, d time.Duration, err error)
+
+	// Evicted is called when key leaves the main or hot cache.
+	Evicted(key string, cache CacheType, reason EvictReason)
+}
+
+// EvictReason is why an entry left a cache.
+type EvictReason int
+
+const (
+	// EvictCapacity means the entry was evicted to keep the group
+	// within its cache bytes.
+	EvictCapacity EvictReason = iota + 1
+
+	// EvictExpired means the entry was found to have expired.
+	EvictExpired
+
+	// EvictRemoved means the entry was removed explicitly, as by
+	// Group.Remove or Group.Set.
+	EvictRemoved
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// NopObserver is an Observer that ignores all events.
+type NopObserver struct{}
+
+func (NopObserver) CacheHit(key string, cache CacheType)                       {}
+func (NopObserver) PeerFetchStart(key, peer string)                            {}
+func (NopObserver) PeerFetchDone(key, peer string, d time.Duration, err error) {}
+func (NopObserver) LocalLoad(key string, d time.Duration, err error)           {}
+func (NopObserver) Evicted(key string, cache CacheType, reason EvictReason)    {}
+
+// observer returns the group's Observer, or a NopObserver.
+func (g *Group) observer() Observer {
+	if g.Observer != nil {
+		return g.Observer
+	}
+	return NopObserver{}
+}
+
+// peerID returns a name for peer to report to Observers: its String
+// method's result if it is a fmt.Stringer, or else its type.
+func peerID(peer ProtoGetter) string {
+	if s, ok := peer.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", peer)
+}
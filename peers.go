@@ -19,17 +19,46 @@ limitations under the License.
 package groupcache
 
 import (
+	"context"
+
 	pb "github.com/golang/groupcache/groupcachepb"
 )
 
-// Context is an opaque value passed through calls to the
-// ProtoGetter. It may be nil if your ProtoGetter implementation does
-// not require a context.
-type Context interface{}
+// Context is the context passed through calls to Getters and
+// ProtoGetters. It carries deadlines and cancellation: a cancelled
+// caller aborts any outstanding peer request and stops waiting for
+// a load started by another caller.
+//
+// Context is an alias for context.Context so that Getters written
+// against the old groupcache.Context signatures continue to compile.
+// For compatibility a nil Context is accepted by Group.Get and is
+// treated as context.Background().
+type Context = context.Context
 
 // ProtoGetter is the interface that must be implemented by a peer.
+// A ProtoGetter may also implement fmt.Stringer to identify its peer
+// to Observers.
 type ProtoGetter interface {
-	Get(context Context, in *pb.GetRequest, out *pb.GetResponse) error
+	Get(ctx Context, in *pb.GetRequest, out *pb.GetResponse) error
+}
+
+// ProtoMultiGetter is optionally implemented by a ProtoGetter whose
+// peer can answer requests for several keys at once. It is used by
+// Group.GetMulti.
+type ProtoMultiGetter interface {
+	GetMulti(ctx Context, in *pb.GetMultiRequest, out *pb.GetMultiResponse) error
+}
+
+// ProtoRemover is optionally implemented by a ProtoGetter whose peer
+// can remove keys from its caches. It is used by Group.Remove.
+type ProtoRemover interface {
+	Remove(ctx Context, in *pb.RemoveRequest, out *pb.RemoveResponse) error
+}
+
+// ProtoSetter is optionally implemented by a ProtoGetter whose peer
+// can accept values pushed into its cache. It is used by Group.Set.
+type ProtoSetter interface {
+	Set(ctx Context, in *pb.SetRequest, out *pb.SetResponse) error
 }
 
 // PeerPicker is the interface that must be implemented to locate
@@ -41,31 +70,45 @@ type PeerPicker interface {
 	PickPeer(key string) (peer ProtoGetter, ok bool)
 }
 
+// MultiPeerPicker is optionally implemented by a PeerPicker that can
+// nominate fallback owners for a key, to be tried in turn when the
+// key's owner can't be reached.
+type MultiPeerPicker interface {
+	// PickPeers returns up to n distinct peers for key in order of
+	// preference, starting with its owner. If the current process
+	// is one of the key's first n owners, the list ends just
+	// before it and isOwner is true: rather than asking peers
+	// further down the list, the current process loads the key
+	// itself.
+	PickPeers(key string, n int) (peers []ProtoGetter, isOwner bool)
+}
+
+// OwnerPicker is optionally implemented by a PeerPicker whose
+// PickPeer may nominate a peer other than a key's owner, as HTTPPool
+// does when the owner's circuit is open. Group.Remove and Group.Set
+// use it so that they reach the owner or fail.
+type OwnerPicker interface {
+	// PickOwner is like PickPeer, but returns the key's owner
+	// regardless of its health.
+	PickOwner(key string) (peer ProtoGetter, ok bool)
+}
+
+// PeerLister is optionally implemented by a PeerPicker that can
+// enumerate all of its peers, not including the current process.
+// The owner of a removed key uses it to invalidate copies of the key
+// held in other peers' hot caches.
+type PeerLister interface {
+	ListPeers() []ProtoGetter
+}
+
 // NoPeers is an implementation of PeerPicker that never finds a peer.
 type NoPeers struct{}
 
 func (NoPeers) PickPeer(key string) (peer ProtoGetter, ok bool) { return }
 
-var (
-	portPicker func() PeerPicker
-)
-
-// RegisterPeerPicker registers the peer initialization function.
+// RegisterPeerPicker registers the peer initialization function
+// with DefaultRegistry.
 // It is called once, when the first group is created.
 func RegisterPeerPicker(fn func() PeerPicker) {
-	if portPicker != nil {
-		panic("RegisterPeerPicker called more than once")
-	}
-	portPicker = fn
-}
-
-func getPeers() PeerPicker {
-	if portPicker == nil {
-		return NoPeers{}
-	}
-	pk := portPicker()
-	if pk == nil {
-		pk = NoPeers{}
-	}
-	return pk
+	DefaultRegistry.RegisterPeerPicker(fn)
 }
@@ -0,0 +1,113 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// peerstats.go records HTTPPool's per-peer client metrics.
+
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// PeerStats are the metrics of an HTTPPool's requests to one peer.
+type PeerStats struct {
+	URL      string // the peer's base URL
+	Requests int64  // requests sent to the peer
+
+	// Latency is the distribution of request latencies, in
+	// nanoseconds, including requests that failed.
+	Latency HistogramSnapshot
+
+	// ResponseBytes is the distribution of the sizes of response
+	// bodies read from the peer.
+	ResponseBytes HistogramSnapshot
+
+	// The failed requests, by cause.
+	Timeouts          int64 // the request timed out
+	ConnectionRefused int64 // the peer refused the connection
+	BadStatus         int64 // the peer answered with a status other than 200
+	DecodeErrors      int64 // the peer's answer couldn't be decoded
+	Canceled          int64 // the caller gave up on the request
+	OtherErrors       int64 // any other failure to reach the peer
+}
+
+// peerStats accumulates the PeerStats of an httpGetter.
+type peerStats struct {
+	requests      AtomicInt
+	latency       Histogram
+	responseBytes Histogram
+	errors        [numErrClasses]AtomicInt
+}
+
+// errClass is the cause of a failed request.
+type errClass int
+
+const (
+	errNone errClass = iota
+	errTimeout
+	errRefused
+	errBadStatus
+	errDecode
+	errCanceled
+	errOther
+	numErrClasses
+)
+
+// transportErrClass classifies an error reaching a peer or reading
+// its response.
+func transportErrClass(err error) errClass {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return errCanceled
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return errTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errRefused
+	}
+	return errOther
+}
+
+// record accounts for a request that took d and read n bytes of
+// response body.
+func (s *peerStats) record(d time.Duration, n int, class errClass) {
+	s.requests.Add(1)
+	s.latency.Observe(int64(d))
+	if class == errNone || class == errBadStatus || class == errDecode {
+		s.responseBytes.Observe(int64(n))
+	}
+	s.errors[class].Add(1)
+}
+
+func (s *peerStats) snapshot(url string) PeerStats {
+	return PeerStats{
+		URL:               url,
+		Requests:          s.requests.Get(),
+		Latency:           s.latency.Snapshot(),
+		ResponseBytes:     s.responseBytes.Snapshot(),
+		Timeouts:          s.errors[errTimeout].Get(),
+		ConnectionRefused: s.errors[errRefused].Get(),
+		BadStatus:         s.errors[errBadStatus].Get(),
+		DecodeErrors:      s.errors[errDecode].Get(),
+		Canceled:          s.errors[errCanceled].Get(),
+		OtherErrors:       s.errors[errOther].Get(),
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"sort"
+	"sync"
+)
+
+// A Registry is a set of named groups together with the PeerPicker
+// and hooks they share. Independent caches in the same process, such
+// as those created by parallel tests, each use their own Registry.
+//
+// The package-level functions NewGroup, GetGroup, RegisterPeerPicker,
+// RegisterNewGroupHook and RegisterServerStart operate on
+// DefaultRegistry.
+type Registry struct {
+	mu     sync.RWMutex
+	groups map[string]*Group
+
+	initPeerServerOnce sync.Once
+	initPeerServer     func()
+
+	// newGroupHook, if non-nil, is called right after a new group is created.
+	newGroupHook func(*Group)
+
+	peerPicker func() PeerPicker
+}
+
+// DefaultRegistry is the Registry used by the package-level functions.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{groups: make(map[string]*Group)}
+}
+
+// GetGroup returns the named group previously created with r.NewGroup,
+// or nil if there's no such group.
+func (r *Registry) GetGroup(name string) *Group {
+	r.mu.RLock()
+	g := r.groups[name]
+	r.mu.RUnlock()
+	return g
+}
+
+// Groups returns the groups registered with r, sorted by name.
+func (r *Registry) Groups() []*Group {
+	r.mu.RLock()
+	groups := make([]*Group, 0, len(r.groups))
+	for _, g := range r.groups {
+		groups = append(groups, g)
+	}
+	r.mu.RUnlock()
+	sort.Slice(groups, func(i, j int) bool { return groups[i].name < groups[j].name })
+	return groups
+}
+
+// NewGroup is like the package-level NewGroup, but registers the
+// group with r. The group name must be unique within r.
+func (r *Registry) NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+	return r.newGroup(name, cacheBytes, getter, nil)
+}
+
+// NewGroupWithOptions is like NewGroup, with the group configured
+// by opts.
+func (r *Registry) NewGroupWithOptions(name string, cacheBytes int64, getter Getter, opts GroupOptions) *Group {
+	if getter == nil {
+		panic("nil Getter")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.initPeerServerOnce.Do(r.callInitPeerServer)
+	if _, dup := r.groups[name]; dup {
+		panic("duplicate registration of group " + name)
+	}
+	g := &Group{
+		name:         name,
+		registry:     r,
+		getter:       getter,
+		cacheBytes:   cacheBytes,
+		GroupOptions: opts,
+	}
+	if fn := r.newGroupHook; fn != nil {
+		fn(g)
+	}
+	r.groups[name] = g
+	return g
+}
+
+// If peers is nil, the peerPicker is called via a sync.Once to initialize it.
+func (r *Registry) newGroup(name string, cacheBytes int64, getter Getter, peers PeerPicker) *Group {
+	return r.NewGroupWithOptions(name, cacheBytes, getter, GroupOptions{Peers: peers})
+}
+
+// UnregisterGroup removes the named group from r, so that it is no
+// longer served to peers and its name may be reused. Existing
+// references to the group remain usable.
+func (r *Registry) UnregisterGroup(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.groups, name)
+}
+
+// RegisterNewGroupHook registers a hook that is run each time
+// a group is created in r.
+func (r *Registry) RegisterNewGroupHook(fn func(*Group)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.newGroupHook != nil {
+		panic("RegisterNewGroupHook called more than once")
+	}
+	r.newGroupHook = fn
+}
+
+// RegisterServerStart registers a hook that is run when the first
+// group is created in r.
+func (r *Registry) RegisterServerStart(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.initPeerServer != nil {
+		panic("RegisterServerStart called more than once")
+	}
+	r.initPeerServer = fn
+}
+
+func (r *Registry) callInitPeerServer() {
+	if r.initPeerServer != nil {
+		r.initPeerServer()
+	}
+}
+
+// RegisterPeerPicker registers the peer initialization function for
+// groups in r. It is called once per group, when the group is first
+// used.
+func (r *Registry) RegisterPeerPicker(fn func() PeerPicker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.peerPicker != nil {
+		panic("RegisterPeerPicker called more than once")
+	}
+	r.peerPicker = fn
+}
+
+func (r *Registry) getPeers() PeerPicker {
+	r.mu.RLock()
+	fn := r.peerPicker
+	r.mu.RUnlock()
+	if fn == nil {
+		return NoPeers{}
+	}
+	pk := fn()
+	if pk == nil {
+		pk = NoPeers{}
+	}
+	return pk
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testPeer is an in-process peer: a Registry served over HTTP.
+type testPeer struct {
+	reg  *Registry
+	pool *HTTPPool
+	srv  *httptest.Server
+}
+
+// newTestPeers starts n in-process peers, each knowing about all the
+// others, with a group called name whose values are prefixed by the
+// index of the peer that loaded them.
+func newTestPeers(t *testing.T, n int, name string) []*testPeer {
+	peers := make([]*testPeer, n)
+	var urls []string
+	for i := range peers {
+		tp := &testPeer{reg: NewRegistry()}
+		tp.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tp.pool.ServeHTTP(w, r)
+		}))
+		t.Cleanup(tp.srv.Close)
+		peers[i] = tp
+		urls = append(urls, tp.srv.URL)
+	}
+	for i, tp := range peers {
+		tp.pool = tp.reg.NewHTTPPool(tp.srv.URL)
+		tp.pool.Set(urls...)
+		prefix := strconv.Itoa(i) + ":"
+		tp.reg.NewGroup(name, 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+			return dest.SetString(prefix + key)
+		}))
+	}
+	return peers
+}
+
+func TestRegistryIsolation(t *testing.T) {
+	peers := newTestPeers(t, 3, "registry-test")
+
+	index := make(map[string]string) // peer base URL to index
+	for i, tp := range peers {
+		index[tp.srv.URL+defaultBasePath] = strconv.Itoa(i)
+	}
+	for _, key := range testKeys(30) {
+		var want string
+		for i, tp := range peers {
+			var got string
+			if err := tp.reg.GetGroup("registry-test").Get(dummyCtx, key, StringSink(&got)); err != nil {
+				t.Fatalf("peer %d: Get(%q) = %v", i, key, err)
+			}
+			if !strings.HasSuffix(got, ":"+key) {
+				t.Errorf("peer %d: Get(%q) = %q, want value ending in %q", i, key, got, ":"+key)
+			}
+			if want == "" {
+				want = got
+			} else if got != want {
+				t.Errorf("peer %d: Get(%q) = %q; peer 0 got %q", i, key, got, want)
+			}
+		}
+		owner := "0"
+		if peer, ok := peers[0].pool.PickPeer(key); ok {
+			owner = index[peer.(*httpGetter).baseURL]
+		}
+		if loader := want[:strings.Index(want, ":")]; loader != owner {
+			t.Errorf("Get(%q) loaded by peer %s; want owner %s", key, loader, owner)
+		}
+	}
+	if g := GetGroup("registry-test"); g != nil {
+		t.Error("group registered in a Registry leaked into DefaultRegistry")
+	}
+}
+
+func TestUnregisterGroup(t *testing.T) {
+	r := NewRegistry()
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString(key)
+	})
+	g := r.NewGroup("unregister-test", 0, getter)
+	if r.GetGroup("unregister-test") != g {
+		t.Fatal("GetGroup didn't return the new group")
+	}
+	r.UnregisterGroup("unregister-test")
+	if r.GetGroup("unregister-test") != nil {
+		t.Error("GetGroup returned an unregistered group")
+	}
+	// The name may be reused.
+	r.NewGroup("unregister-test", 0, getter)
+}
@@ -18,13 +18,16 @@ limitations under the License.
 // mechanism.
 package singleflight
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 // call is an in-flight or completed Do call
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done chan struct{} // closed when val and err are set
+	val  interface{}
+	err  error
 }
 
 // Group represents a class of work and forms a namespace in which
@@ -39,22 +42,33 @@ type Group struct {
 // time. If a duplicate comes in, the duplicate caller waits for the
 // original to complete and receives the same results.
 func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	return g.DoContext(context.Background(), key, fn)
+}
+
+// DoContext is like Do, but a duplicate caller stops waiting and
+// returns ctx.Err() if ctx is done before the original call
+// completes. The original call itself is not interrupted; fn is
+// responsible for observing any context it needs.
+func (g *Group) DoContext(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
 	g.mu.Lock()
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
 	if c, ok := g.m[key]; ok {
 		g.mu.Unlock()
-		c.wg.Wait()
-		return c.val, c.err
+		select {
+		case <-c.done:
+			return c.val, c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
-	c := new(call)
-	c.wg.Add(1)
+	c := &call{done: make(chan struct{})}
 	g.m[key] = c
 	g.mu.Unlock()
 
 	c.val, c.err = fn()
-	c.wg.Done()
+	close(c.done)
 
 	g.mu.Lock()
 	delete(g.m, key)
@@ -62,3 +76,54 @@ func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, err
 
 	return c.val, c.err
 }
+
+// DoMulti is like DoContext for several keys at once. Keys with a
+// call already in flight, including keys repeated in keys, wait for
+// that call. fn is called at most once, with the remaining keys, and
+// must return a value and an error for each of them, in order.
+// DoMulti returns a value and an error for each of keys.
+func (g *Group) DoMulti(ctx context.Context, keys []string, fn func(keys []string) ([]interface{}, []error)) ([]interface{}, []error) {
+	calls := make([]*call, len(keys))
+	var lead []string
+	var leadCalls []*call
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	for i, key := range keys {
+		c, ok := g.m[key]
+		if !ok {
+			c = &call{done: make(chan struct{})}
+			g.m[key] = c
+			lead = append(lead, key)
+			leadCalls = append(leadCalls, c)
+		}
+		calls[i] = c
+	}
+	g.mu.Unlock()
+
+	if len(lead) > 0 {
+		vals, errs := fn(lead)
+		for i, c := range leadCalls {
+			c.val, c.err = vals[i], errs[i]
+			close(c.done)
+		}
+		g.mu.Lock()
+		for _, key := range lead {
+			delete(g.m, key)
+		}
+		g.mu.Unlock()
+	}
+
+	vals := make([]interface{}, len(keys))
+	errs := make([]error, len(keys))
+	for i, c := range calls {
+		select {
+		case <-c.done:
+			vals[i], errs[i] = c.val, c.err
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+		}
+	}
+	return vals, errs
+}
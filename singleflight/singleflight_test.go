@@ -17,6 +17,7 @@ limitations under the License.
 package singleflight
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -45,7 +46,7 @@ func TestDoErr(t *testing.T) {
 		return nil, someErr
 	})
 	if err != someErr {
-		t.Errorf("Do error = %v; want someErr", err, someErr)
+		t.Errorf("Do error = %v; want %v", err, someErr)
 	}
 	if v != nil {
 		t.Errorf("unexpected non-nil value %#v", v)
@@ -83,3 +84,70 @@ func TestDoDupSuppress(t *testing.T) {
 		t.Errorf("number of calls = %d; want 1", got)
 	}
 }
+
+func TestDoContextCancelDuplicate(t *testing.T) {
+	var g Group
+	c := make(chan string)
+	started := make(chan bool)
+	go g.Do("key", func() (interface{}, error) {
+		started <- true
+		return <-c, nil
+	})
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := g.DoContext(ctx, "key", func() (interface{}, error) {
+			t.Error("duplicate call ran fn")
+			return nil, nil
+		})
+		errc <- err
+	}()
+	cancel()
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Errorf("DoContext error = %v; want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for cancelled DoContext to return")
+	}
+	c <- "bar"
+}
+
+func TestDoMulti(t *testing.T) {
+	var g Group
+	c := make(chan string)
+	started := make(chan bool)
+	go g.Do("shared", func() (interface{}, error) {
+		started <- true
+		return <-c, nil
+	})
+	<-started
+
+	var got []string
+	go func() {
+		time.Sleep(100 * time.Millisecond) // let DoMulti block on "shared"
+		c <- "from Do"
+	}()
+	vals, errs := g.DoMulti(context.Background(), []string{"a", "shared", "b", "a"}, func(keys []string) ([]interface{}, []error) {
+		got = keys
+		vals := make([]interface{}, len(keys))
+		for i, key := range keys {
+			vals[i] = "fn:" + key
+		}
+		return vals, make([]error, len(keys))
+	})
+	if want := []string{"a", "b"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("fn called with %q; want %q", got, want)
+	}
+	if want := "[fn:a from Do fn:b fn:a]"; fmt.Sprint(vals) != want {
+		t.Errorf("DoMulti values = %v; want %v", vals, want)
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("DoMulti error %d = %v", i, err)
+		}
+	}
+}
@@ -18,6 +18,7 @@ package groupcache
 
 import (
 	"errors"
+	"time"
 
 	"code.google.com/p/goprotobuf/proto"
 )
@@ -38,6 +39,12 @@ type Sink interface {
 	// The caller retains ownership of m.
 	SetProto(m proto.Message) error
 
+	// SetExpiry sets the time after which the value must no
+	// longer be served from any cache. The zero Time, the
+	// default, means the value never expires. It may be called
+	// before or after the other Set methods.
+	SetExpiry(t time.Time)
+
 	// view returns a frozen view of the bytes for caching.
 	view() (ByteView, error)
 }
@@ -49,6 +56,14 @@ func cloneBytes(b []byte) []byte {
 }
 
 func setSinkView(s Sink, v ByteView) error {
+	if es, ok := s.(*encodedSink); ok {
+		return es.setView(v)
+	}
+	v, err := v.decode()
+	if err != nil {
+		return err
+	}
+
 	// A viewSetter is a Sink that can also receive its value from
 	// a ByteView. This is a fast path to minimize copies when the
 	// item was already cached locally in memory (where it's
@@ -60,9 +75,14 @@ func setSinkView(s Sink, v ByteView) error {
 		return vs.setView(v)
 	}
 	if v.b != nil {
-		return s.SetBytes(v.b)
+		if err := s.SetBytes(v.b); err != nil {
+			return err
+		}
+	} else if err := s.SetString(v.s); err != nil {
+		return err
 	}
-	return s.SetString(v.s)
+	s.SetExpiry(v.e)
+	return nil
 }
 
 // StringSink returns a Sink that populates the provided string pointer.
@@ -92,6 +112,10 @@ func (s *stringSink) SetBytes(v []byte) error {
 	return s.SetString(string(v))
 }
 
+func (s *stringSink) SetExpiry(t time.Time) {
+	s.v.e = t
+}
+
 func (s *stringSink) SetProto(m proto.Message) error {
 	b, err := proto.Marshal(m)
 	if err != nil {
@@ -112,6 +136,7 @@ func ByteViewSink(dst *ByteView) Sink {
 
 type byteViewSink struct {
 	dst *ByteView
+	e   time.Time
 
 	// if this code ever ends up tracking that at least one set*
 	// method was called, don't make it an error to call set
@@ -125,6 +150,7 @@ type byteViewSink struct {
 
 func (s *byteViewSink) setView(v ByteView) error {
 	*s.dst = v
+	s.e = v.e
 	return nil
 }
 
@@ -137,20 +163,36 @@ func (s *byteViewSink) SetProto(m proto.Message) error {
 	if err != nil {
 		return err
 	}
-	*s.dst = ByteView{b: b}
+	*s.dst = ByteView{b: b, e: s.e}
 	return nil
 }
 
 func (s *byteViewSink) SetBytes(b []byte) error {
-	*s.dst = ByteView{b: cloneBytes(b)}
+	*s.dst = ByteView{b: cloneBytes(b), e: s.e}
 	return nil
 }
 
 func (s *byteViewSink) SetString(v string) error {
-	*s.dst = ByteView{s: v}
+	*s.dst = ByteView{s: v, e: s.e}
 	return nil
 }
 
+func (s *byteViewSink) SetExpiry(t time.Time) {
+	s.e = t
+	s.dst.e = t
+}
+
+// encodedViewSink returns a Sink like ByteViewSink's, except that
+// values found in the group's caches are set as they are cached,
+// still encoded by the group's Codec, to be passed on to peers.
+func encodedViewSink(dst *ByteView) Sink {
+	return &encodedSink{byteViewSink{dst: dst}}
+}
+
+type encodedSink struct {
+	byteViewSink
+}
+
 // ProtoSink returns a sink that unmarshals binary proto values into m.
 func ProtoSink(m proto.Message) Sink {
 	return &protoSink{
@@ -169,6 +211,10 @@ func (s *protoSink) view() (ByteView, error) {
 	return s.v, nil
 }
 
+func (s *protoSink) SetExpiry(t time.Time) {
+	s.v.e = t
+}
+
 func (s *protoSink) SetBytes(b []byte) error {
 	err := proto.Unmarshal(b, s.dst)
 	if err != nil {
@@ -234,6 +280,10 @@ func (s *allocBytesSink) setView(v ByteView) error {
 	return nil
 }
 
+func (s *allocBytesSink) SetExpiry(t time.Time) {
+	s.v.e = t
+}
+
 func (s *allocBytesSink) SetProto(m proto.Message) error {
 	b, err := proto.Marshal(m)
 	if err != nil {
@@ -283,6 +333,10 @@ func (s *truncBytesSink) view() (ByteView, error) {
 	return s.v, nil
 }
 
+func (s *truncBytesSink) SetExpiry(t time.Time) {
+	s.v.e = t
+}
+
 func (s *truncBytesSink) SetProto(m proto.Message) error {
 	b, err := proto.Marshal(m)
 	if err != nil {
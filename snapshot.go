@@ -0,0 +1,358 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// snapshot.go implements saving a group's caches and restoring them,
+// so that a restarted process needn't start cold.
+
+package groupcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A snapshot is the magic string and version, the group's name, the
+// entries and an end marker, followed by a CRC-32C of everything
+// before it. Each entry is a record type, saying which cache it came
+// from, then its key, value and expiry time in Unix nanoseconds, or
+// zero if it has none. Strings are written as their uvarint length
+// and bytes, times as varints. The entries of each cache are in the
+// order its eviction policy would evict them.
+const (
+	snapshotMagic   = "groupcache-snapshot"
+	snapshotVersion = 1
+
+	snapshotEnd  = 0
+	snapshotMain = 1
+	snapshotHot  = 2
+
+	// maxSnapshotString bounds the strings Restore allocates, in
+	// case a corrupt length gets past the checksum's protection
+	// only once the whole snapshot has been read.
+	maxSnapshotString = 1 << 30
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// Snapshot writes the entries of the group's main cache, and of its
+// hot cache if SnapshotHotCache is set, to w. Restore reads them
+// back.
+func (g *Group) Snapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	sw := &snapshotWriter{w: bw, crc: crc32.New(crc32c)}
+	sw.writeString(snapshotMagic)
+	sw.writeUvarint(snapshotVersion)
+	sw.writeString(g.name)
+	sw.writeCache(&g.mainCache, snapshotMain)
+	if g.SnapshotHotCache {
+		sw.writeCache(&g.hotCache, snapshotHot)
+	}
+	sw.writeByte(snapshotEnd)
+	if sw.err != nil {
+		return sw.err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], sw.crc.Sum32())
+	if _, err := bw.Write(sum[:]); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// snapshotWriter writes a snapshot, keeping its checksum and the
+// first error encountered.
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (sw *snapshotWriter) write(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	sw.crc.Write(p)
+	_, sw.err = sw.w.Write(p)
+}
+
+func (sw *snapshotWriter) writeByte(b byte) {
+	sw.write([]byte{b})
+}
+
+func (sw *snapshotWriter) writeUvarint(x uint64) {
+	sw.write(sw.buf[:binary.PutUvarint(sw.buf[:], x)])
+}
+
+func (sw *snapshotWriter) writeVarint(x int64) {
+	sw.write(sw.buf[:binary.PutVarint(sw.buf[:], x)])
+}
+
+func (sw *snapshotWriter) writeString(s string) {
+	sw.writeUvarint(uint64(len(s)))
+	sw.write([]byte(s))
+}
+
+// writeCache writes the entries of c, decoded so that the snapshot
+// doesn't depend on the group's Codec.
+func (sw *snapshotWriter) writeCache(c *cache, typ byte) {
+	c.walk(func(key string, value ByteView) {
+		value, err := value.decode()
+		if err != nil {
+			if sw.err == nil {
+				sw.err = err
+			}
+			return
+		}
+		sw.writeByte(typ)
+		sw.writeString(key)
+		sw.writeUvarint(uint64(value.Len()))
+		if value.b != nil {
+			sw.write(value.b)
+		} else {
+			sw.write([]byte(value.s))
+		}
+		var expire int64
+		if !value.e.IsZero() {
+			expire = value.e.UnixNano()
+		}
+		sw.writeVarint(expire)
+	})
+}
+
+// errBadSnapshot is returned by Restore for a corrupt snapshot.
+var errBadSnapshot = errors.New("groupcache: corrupt snapshot")
+
+// Restore adds the entries in a snapshot written by the group's
+// Snapshot method to the group's caches, in their original order, so
+// that if they don't all fit in the group's cache bytes it is the
+// ones the snapshotted caches valued least that are evicted. The
+// snapshot is checked in full before any entry is added. Expired
+// entries are skipped.
+func (g *Group) Restore(r io.Reader) error {
+	sr := &snapshotReader{r: bufio.NewReader(r), crc: crc32.New(crc32c)}
+	if magic := sr.readString(int64(len(snapshotMagic))); sr.err != nil || magic != snapshotMagic {
+		return errors.New("groupcache: not a snapshot")
+	}
+	if v := sr.readUvarint(); sr.err == nil && v != snapshotVersion {
+		return fmt.Errorf("groupcache: unsupported snapshot version %d", v)
+	}
+	if name := sr.readString(maxSnapshotString); sr.err == nil && name != g.name {
+		return fmt.Errorf("groupcache: snapshot is of group %q, not %q", name, g.name)
+	}
+
+	type record struct {
+		typ   byte
+		key   string
+		value ByteView
+	}
+	var records []record
+	for sr.err == nil {
+		typ := sr.readByte()
+		if typ == snapshotEnd || sr.err != nil {
+			break
+		}
+		if typ != snapshotMain && typ != snapshotHot {
+			return errBadSnapshot
+		}
+		rec := record{typ: typ, key: sr.readString(maxSnapshotString)}
+		rec.value.b = sr.readBytes(maxSnapshotString)
+		if expire := sr.readVarint(); expire != 0 {
+			rec.value.e = time.Unix(0, expire)
+		}
+		records = append(records, rec)
+	}
+	if sr.err != nil {
+		return sr.err
+	}
+	want := sr.crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(sr.r, sum[:]); err != nil || binary.BigEndian.Uint32(sum[:]) != want {
+		return errBadSnapshot
+	}
+
+	now := time.Now()
+	for _, rec := range records {
+		if rec.value.expired(now) {
+			// Adding it would only evict the key's live value.
+			continue
+		}
+		cache := &g.mainCache
+		if rec.typ == snapshotHot {
+			cache = &g.hotCache
+		}
+		g.populateCache(rec.key, rec.value, cache)
+	}
+	return nil
+}
+
+// snapshotReader reads a snapshot, keeping the checksum of the bytes
+// read and the first error encountered.
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+// ReadByte implements io.ByteReader, for reading varints.
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	b, err := sr.r.ReadByte()
+	if err == nil {
+		sr.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (sr *snapshotReader) setErr(err error) {
+	if sr.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		sr.err = err
+	}
+}
+
+func (sr *snapshotReader) readByte() byte {
+	if sr.err != nil {
+		return 0
+	}
+	b, err := sr.ReadByte()
+	sr.setErr(err)
+	return b
+}
+
+func (sr *snapshotReader) readUvarint() uint64 {
+	if sr.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(sr)
+	sr.setErr(err)
+	return x
+}
+
+func (sr *snapshotReader) readVarint() int64 {
+	if sr.err != nil {
+		return 0
+	}
+	x, err := binary.ReadVarint(sr)
+	sr.setErr(err)
+	return x
+}
+
+// readBytes reads a length-prefixed byte string of at most max
+// bytes.
+func (sr *snapshotReader) readBytes(max int64) []byte {
+	n := sr.readUvarint()
+	if sr.err != nil {
+		return nil
+	}
+	if n > uint64(max) {
+		sr.setErr(errBadSnapshot)
+		return nil
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(sr.r, b)
+	sr.setErr(err)
+	sr.crc.Write(b)
+	return b
+}
+
+func (sr *snapshotReader) readString(max int64) string {
+	return string(sr.readBytes(max))
+}
+
+// snapshotFile returns the name of the file in dir holding the
+// snapshot of the named group.
+func snapshotFile(dir, name string) string {
+	return filepath.Join(dir, url.PathEscape(name)+".snapshot")
+}
+
+// SaveSnapshots writes a snapshot of each group in DefaultRegistry
+// to dir. It is typically called as the process shuts down.
+func SaveSnapshots(dir string) error {
+	return DefaultRegistry.SaveSnapshots(dir)
+}
+
+// RestoreSnapshots restores each group in DefaultRegistry from its
+// snapshot in dir. It is typically called once the process has
+// created its groups, before it serves requests.
+func RestoreSnapshots(dir string) error {
+	return DefaultRegistry.RestoreSnapshots(dir)
+}
+
+// SaveSnapshots writes a snapshot of each group in r to a file in
+// dir named after the group, replacing any earlier snapshot. Each
+// file is replaced atomically, so a crash leaves the old snapshot
+// intact.
+func (r *Registry) SaveSnapshots(dir string) error {
+	for _, g := range r.Groups() {
+		if err := saveSnapshot(g, snapshotFile(dir, g.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveSnapshot(g *Group, path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails harmlessly once renamed
+	if err := g.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// RestoreSnapshots restores each group in r from its snapshot in
+// dir, as written by SaveSnapshots. Groups without a snapshot are
+// left empty.
+func (r *Registry) RestoreSnapshots(dir string) error {
+	for _, g := range r.Groups() {
+		f, err := os.Open(snapshotFile(dir, g.name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = g.Restore(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("restoring %s: %v", f.Name(), err)
+		}
+	}
+	return nil
+}
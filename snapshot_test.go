@@ -0,0 +1,132 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString(strings.Repeat(key, 9))
+	})
+	src := NewRegistry().newGroup("snap", 1<<20, getter, NoPeers{})
+	var s string
+	for _, key := range []string{"a", "b", "c", "d", "e", "a"} {
+		src.Get(dummyCtx, key, StringSink(&s))
+	}
+	src.Set(dummyCtx, "x", []byte("expired"), time.Now().Add(time.Millisecond), false)
+	src.Set(dummyCtx, "y", []byte("expires"), time.Now().Add(time.Hour), false)
+	time.Sleep(2 * time.Millisecond)
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snap := buf.Bytes()
+
+	// Room for the three most recently used values, each 10 bytes.
+	dst := NewRegistry().newGroup("snap", 30, getter, NoPeers{})
+	if err := dst.Restore(bytes.NewReader(snap)); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	dst.mainCache.walk(func(key string, value ByteView) {
+		got = append(got, key+"="+value.String())
+	})
+	if want := []string{"e=eeeeeeeee", "a=aaaaaaaaa", "y=expires"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("restored %v; want %v", got, want)
+	}
+	if v, ok := dst.mainCache.get("y", 0); !ok || v.Expire().IsZero() {
+		t.Error("restored value lost its expiry")
+	}
+
+	for _, tt := range []struct {
+		name string
+		snap []byte
+	}{
+		{"truncated", snap[:len(snap)-1]},
+		{"corrupt", append(append([]byte(nil), snap[:40]...), append([]byte{snap[40] ^ 1}, snap[41:]...)...)},
+		{"not a snapshot", []byte("hello")},
+	} {
+		g := NewRegistry().newGroup("snap", 1<<20, getter, NoPeers{})
+		if err := g.Restore(bytes.NewReader(tt.snap)); err == nil {
+			t.Errorf("%s: Restore succeeded", tt.name)
+		}
+		if n := g.mainCache.items(); n != 0 {
+			t.Errorf("%s: Restore added %d items", tt.name, n)
+		}
+	}
+	other := NewRegistry().newGroup("other", 1<<20, getter, NoPeers{})
+	if err := other.Restore(bytes.NewReader(snap)); err == nil || !strings.Contains(err.Error(), `"snap"`) {
+		t.Errorf("Restore into another group = %v; want error naming the snapshot's group", err)
+	}
+}
+
+// tests that a record that expired after its snapshot was taken
+// doesn't replace the key's live value.
+func TestRestoreExpired(t *testing.T) {
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("loaded")
+	})
+	src := NewRegistry().newGroup("snap", 1<<20, getter, NoPeers{})
+	src.Set(dummyCtx, "k", []byte("old"), time.Now().Add(10*time.Millisecond), false)
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	dst := NewRegistry().newGroup("snap", 1<<20, getter, NoPeers{})
+	dst.Set(dummyCtx, "k", []byte("live"), time.Time{}, false)
+	if err := dst.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := dst.mainCache.get("k", 0); !ok || v.String() != "live" {
+		t.Errorf("after Restore, cached k = %q, %v; want live", v.String(), ok)
+	}
+}
+
+func TestSnapshotHotCache(t *testing.T) {
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("local")
+	})
+	opts := GroupOptions{
+		Peers:            ringPeers{&fakePeer{}},
+		HotCachePolicy:   FixedHotCachePolicy{Mirror: true, HotRatio: 1},
+		SnapshotHotCache: true,
+	}
+	src := NewRegistry().NewGroupWithOptions("snap", 1<<20, getter, opts)
+	var s string
+	src.Get(dummyCtx, "remote", StringSink(&s))
+
+	dir := t.TempDir()
+	if err := src.registry.SaveSnapshots(dir); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry()
+	dst := r.NewGroupWithOptions("snap", 1<<20, getter, opts)
+	r.NewGroupWithOptions("unsaved", 1<<20, getter, opts)
+	if err := r.RestoreSnapshots(dir); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := dst.hotCache.get("remote", 0); !ok || v.String() != "got:remote" {
+		t.Errorf("hot cache entry = %q, %v; want restored", v.String(), ok)
+	}
+}
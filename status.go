@@ -0,0 +1,208 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// status.go implements the /groupcachez debug status page.
+
+package groupcache
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRecentErrors is the number of errors a group keeps for its
+// status page.
+const maxRecentErrors = 32
+
+// An ErrorRecord describes a recent failure in a group.
+type ErrorRecord struct {
+	Time time.Time
+	Op   string // "peer" for a failed peer request, "load" for a failed Getter
+	Key  string
+	Err  string
+}
+
+// errorLog is a ring buffer of a group's most recent errors.
+type errorLog struct {
+	mu   sync.Mutex
+	recs [maxRecentErrors]ErrorRecord
+	n    int // errors ever added
+}
+
+func (l *errorLog) add(op, key string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recs[l.n%maxRecentErrors] = ErrorRecord{time.Now(), op, key, err.Error()}
+	l.n++
+}
+
+// recent returns the errors in the log, newest first.
+func (l *errorLog) recent() []ErrorRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var recs []ErrorRecord
+	for i := l.n - 1; i >= 0 && i >= l.n-maxRecentErrors; i-- {
+		recs = append(recs, l.recs[i%maxRecentErrors])
+	}
+	return recs
+}
+
+// RecentErrors returns the group's most recent failed requests to
+// peers and failed loads by its Getter, newest first.
+func (g *Group) RecentErrors() []ErrorRecord {
+	return g.errLog.recent()
+}
+
+// StatusHandler returns an http.Handler serving a status page for
+// the groups in DefaultRegistry. It is typically registered at
+// "/groupcachez".
+func StatusHandler() http.Handler {
+	return DefaultRegistry.StatusHandler()
+}
+
+// StatusHandler returns an http.Handler serving a status page for
+// the groups in r: their statistics, the peers of r's HTTPPool, if
+// any, and each group's recent errors. The page is HTML, or JSON if
+// requested with the query parameter "format=json" or an Accept
+// header preferring application/json.
+func (r *Registry) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		st := r.status()
+		if req.FormValue("format") == "json" || strings.HasPrefix(req.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.Encode(st)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusTemplate.Execute(w, st); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// registryStatus is the content of a status page.
+type registryStatus struct {
+	Self   string       `json:",omitempty"`
+	Peers  []string     `json:",omitempty"`
+	Health []PeerStatus `json:",omitempty"`
+	Groups []*groupStatus
+}
+
+type groupStatus struct {
+	Name          string
+	Stats         map[string]int64
+	MainCache     CacheStats
+	HotCache      CacheStats
+	DiskCache     *CacheStats `json:",omitempty"`
+	Distributions []histogramStatus
+	Errors        []ErrorRecord
+}
+
+// histogramStatus summarizes a histogram, in the units of its
+// metric.
+type histogramStatus struct {
+	Name          string
+	Count         int64
+	Mean          float64
+	P50, P90, P99 float64
+}
+
+func (r *Registry) status() *registryStatus {
+	st := &registryStatus{}
+	for _, g := range r.Groups() {
+		if p, ok := g.initializedPeers().(*HTTPPool); ok && st.Peers == nil {
+			st.Self = p.self
+			st.Peers = p.Peers()
+			st.Health = p.PeerStatus()
+		}
+		gs := &groupStatus{
+			Name:      g.name,
+			Stats:     make(map[string]int64),
+			MainCache: g.CacheStats(MainCache),
+			HotCache:  g.CacheStats(HotCache),
+			Errors:    g.RecentErrors(),
+		}
+		if g.DiskCache != nil {
+			disk := g.CacheStats(DiskTier)
+			gs.DiskCache = &disk
+		}
+		for _, m := range groupMetrics {
+			gs.Stats[m.name] = m.value(&g.Stats).Get()
+		}
+		for _, m := range histogramMetrics {
+			s := m.value(&g.Histograms).Snapshot()
+			gs.Distributions = append(gs.Distributions, histogramStatus{
+				Name:  m.name,
+				Count: s.Count,
+				Mean:  s.Mean() * m.scale,
+				P50:   float64(s.Percentile(50)) * m.scale,
+				P90:   float64(s.Percentile(90)) * m.scale,
+				P99:   float64(s.Percentile(99)) * m.scale,
+			})
+		}
+		st.Groups = append(st.Groups, gs)
+	}
+	return st
+}
+
+var statusTemplate = template.Must(template.New("groupcachez").Parse(`<!DOCTYPE html>
+<html>
+<head><title>groupcache status</title></head>
+<body>
+<h1>groupcache status</h1>
+{{if .Peers}}
+<h2>Peers</h2>
+<p>This peer: {{.Self}}</p>
+<ul>{{range .Peers}}<li>{{.}}</li>{{end}}</ul>
+{{if .Health}}
+<table border="1">
+<tr><th>Peer</th><th>Circuit</th><th>Consecutive failures</th><th>Latency</th><th>Requests</th><th>Failures</th><th>Trips</th></tr>
+{{range .Health}}<tr><td>{{.URL}}</td><td>{{.State}}</td><td>{{.ConsecutiveFailures}}</td><td>{{.Latency}}</td><td>{{.Requests}}</td><td>{{.Failures}}</td><td>{{.Trips}}</td></tr>
+{{end}}</table>
+{{end}}
+{{end}}
+{{range .Groups}}
+<h2>Group {{.Name}}</h2>
+<table border="1">
+{{range $name, $value := .Stats}}<tr><td>{{$name}}</td><td>{{$value}}</td></tr>
+{{end}}</table>
+<h3>Caches</h3>
+<table border="1">
+<tr><th>Cache</th><th>Bytes</th><th>Items</th><th>Gets</th><th>Hits</th><th>Evictions</th></tr>
+{{with .MainCache}}<tr><td>main</td><td>{{.Bytes}}</td><td>{{.Items}}</td><td>{{.Gets}}</td><td>{{.Hits}}</td><td>{{.Evictions}}</td></tr>{{end}}
+{{with .HotCache}}<tr><td>hot</td><td>{{.Bytes}}</td><td>{{.Items}}</td><td>{{.Gets}}</td><td>{{.Hits}}</td><td>{{.Evictions}}</td></tr>{{end}}
+{{with .DiskCache}}<tr><td>disk</td><td>{{.Bytes}}</td><td>{{.Items}}</td><td>{{.Gets}}</td><td>{{.Hits}}</td><td>{{.Evictions}}</td></tr>{{end}}
+</table>
+<h3>Distributions</h3>
+<table border="1">
+<tr><th>Histogram</th><th>Count</th><th>Mean</th><th>50%</th><th>90%</th><th>99%</th></tr>
+{{range .Distributions}}<tr><td>{{.Name}}</td><td>{{.Count}}</td><td>{{printf "%.6g" .Mean}}</td><td>{{printf "%.6g" .P50}}</td><td>{{printf "%.6g" .P90}}</td><td>{{printf "%.6g" .P99}}</td></tr>
+{{end}}</table>
+<h3>Recent errors</h3>
+{{if .Errors}}<table border="1">
+<tr><th>Time</th><th>Op</th><th>Key</th><th>Error</th></tr>
+{{range .Errors}}<tr><td>{{.Time.Format "2006-01-02 15:04:05.000"}}</td><td>{{.Op}}</td><td>{{.Key}}</td><td>{{.Err}}</td></tr>
+{{end}}</table>{{else}}<p>None.</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
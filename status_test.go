@@ -0,0 +1,111 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatusHandler(t *testing.T) {
+	r := NewRegistry()
+	pool := r.NewHTTPPool("http://a")
+	pool.Set("http://a", "http://b")
+	g := r.NewGroup("status-test", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return errors.New("origin <down>")
+	}))
+	// Pick a key owned by this peer, so the Getter is called.
+	var key string
+	for _, k := range testKeys(100) {
+		if _, ok := pool.PickPeer(k); !ok {
+			key = k
+			break
+		}
+	}
+	var s string
+	g.Get(dummyCtx, key, StringSink(&s))
+
+	w := httptest.NewRecorder()
+	r.StatusHandler().ServeHTTP(w, httptest.NewRequest("GET", "/groupcachez?format=json", nil))
+	var st registryStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	if st.Self != "http://a" || fmt.Sprint(st.Peers) != "[http://a http://b]" {
+		t.Errorf("self, peers = %q, %q; want http://a, [http://a http://b]", st.Self, st.Peers)
+	}
+	if len(st.Health) != 1 || st.Health[0].URL != "http://b" {
+		t.Errorf("peer health = %+v; want one entry for http://b", st.Health)
+	}
+	if len(st.Groups) != 1 {
+		t.Fatalf("got %d groups; want 1", len(st.Groups))
+	}
+	gs := st.Groups[0]
+	if gs.Name != "status-test" || gs.Stats["gets"] != 1 || gs.Stats["local_load_errors"] != 1 {
+		t.Errorf("group status = %+v", gs)
+	}
+	if len(gs.Errors) != 1 || gs.Errors[0].Op != "load" || gs.Errors[0].Key != key || gs.Errors[0].Err != "origin <down>" {
+		t.Errorf("recent errors = %+v", gs.Errors)
+	}
+
+	w = httptest.NewRecorder()
+	r.StatusHandler().ServeHTTP(w, httptest.NewRequest("GET", "/groupcachez", nil))
+	body := w.Body.String()
+	for _, want := range []string{"<h2>Group status-test</h2>", "origin &lt;down&gt;", "http://b"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("status page missing %q; got:\n%s", want, body)
+		}
+	}
+}
+
+// tests that the status page doesn't fix the peers of a group that
+// hasn't served a request yet.
+func TestStatusBeforePeers(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGroup("status-before-peers", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("v")
+	}))
+	if st := r.status(); st.Peers != nil {
+		t.Errorf("peers before registration = %q; want none", st.Peers)
+	}
+	pool := r.NewHTTPPool("http://a")
+	var s string
+	if err := g.Get(dummyCtx, "k", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if g.peers != pool {
+		t.Errorf("group peers = %T; want the pool registered after the status page was read", g.peers)
+	}
+}
+
+func TestErrorLog(t *testing.T) {
+	var l errorLog
+	for i := 0; i < maxRecentErrors+8; i++ {
+		l.add("load", fmt.Sprint(i), errors.New("failed"))
+	}
+	recs := l.recent()
+	if len(recs) != maxRecentErrors {
+		t.Fatalf("got %d errors; want %d", len(recs), maxRecentErrors)
+	}
+	if first, last := recs[0].Key, recs[len(recs)-1].Key; first != fmt.Sprint(maxRecentErrors+7) || last != "8" {
+		t.Errorf("errors run from key %s to %s; want %d to 8", first, last, maxRecentErrors+7)
+	}
+}
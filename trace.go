@@ -0,0 +1,175 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// A Tracer records spans for the work a Group does to serve a key:
+// the cache lookup, the wait for a deduplicated load, requests to
+// peers and calls to the Getter. Spans started for a request that
+// came from a peer are children of the span that sent it, as
+// identified by the W3C traceparent header.
+type Tracer interface {
+	// StartSpan starts a span named name. parent is the span's
+	// parent, which may belong to another process, or the zero
+	// SpanContext if the span is a root.
+	StartSpan(name string, parent SpanContext) Span
+}
+
+// A Span is an operation being traced.
+type Span interface {
+	// SpanContext returns the span's identity, to be propagated
+	// to the spans it parents.
+	SpanContext() SpanContext
+
+	// SetAttribute annotates the span.
+	SetAttribute(key, value string)
+
+	// End ends the span. err is the operation's error, if any.
+	End(err error)
+}
+
+// SpanContext identifies a span, as carried between processes by
+// the W3C traceparent header.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether sc has a non-zero trace and span ID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent returns sc formatted as a version 00 traceparent
+// header value.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%x-%x-%s", sc.TraceID[:], sc.SpanID[:], flags)
+}
+
+var errBadTraceParent = errors.New("groupcache: malformed traceparent")
+
+// ParseTraceParent parses a traceparent header value. Versions after
+// 00 are parsed as far as the fields version 00 defines.
+func ParseTraceParent(s string) (SpanContext, error) {
+	var sc SpanContext
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, errBadTraceParent
+	}
+	var version, flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(s[:2])); err != nil || version[0] == 0xff {
+		return sc, errBadTraceParent
+	}
+	if version[0] == 0 && len(s) != 55 || len(s) > 55 && s[55] != '-' {
+		return sc, errBadTraceParent
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(s[3:35])); err != nil {
+		return sc, errBadTraceParent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(s[36:52])); err != nil {
+		return sc, errBadTraceParent
+	}
+	if _, err := hex.Decode(flags[:], []byte(s[53:55])); err != nil {
+		return sc, errBadTraceParent
+	}
+	if !sc.IsValid() || !isLowerHex(s[:55]) {
+		return SpanContext{}, errBadTraceParent
+	}
+	sc.Sampled = flags[0]&1 != 0
+	return sc, nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if 'A' <= s[i] && s[i] <= 'F' {
+			return false
+		}
+	}
+	return true
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx carrying sc, so that
+// spans a Group starts under ctx are children of sc. It links a
+// Group's spans into a trace begun by the caller.
+func ContextWithSpanContext(ctx Context, sc SpanContext) Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the SpanContext carried by ctx, or
+// the zero SpanContext if there is none.
+func SpanContextFromContext(ctx Context) SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
+
+// startSpan starts a span as a child of the span in ctx, returning a
+// context carrying the new span. If the group has no Tracer, ctx is
+// returned with a span that does nothing.
+func (g *Group) startSpan(ctx Context, name string) (Context, Span) {
+	if g.Tracer == nil {
+		return ctx, nopSpan{}
+	}
+	span := g.Tracer.StartSpan(name, SpanContextFromContext(ctx))
+	span.SetAttribute("group", g.name)
+	return ContextWithSpanContext(ctx, span.SpanContext()), span
+}
+
+// startKeySpan is like startSpan for a span concerning a single key.
+func (g *Group) startKeySpan(ctx Context, name, key string) (Context, Span) {
+	ctx, span := g.startSpan(ctx, name)
+	span.SetAttribute("key", key)
+	return ctx, span
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SpanContext() SpanContext       { return SpanContext{} }
+func (nopSpan) SetAttribute(key, value string) {}
+func (nopSpan) End(err error)                  {}
+
+const traceParentHeader = "traceparent"
+
+// injectSpanContext sets the traceparent header of req from the span
+// carried by its context, if any.
+func injectSpanContext(req *http.Request) {
+	if sc := SpanContextFromContext(req.Context()); sc.IsValid() {
+		req.Header.Set(traceParentHeader, sc.TraceParent())
+	}
+}
+
+// extractSpanContext returns ctx carrying the span identified by the
+// traceparent header of r, if it has a valid one.
+func extractSpanContext(ctx Context, r *http.Request) Context {
+	sc, err := ParseTraceParent(r.Header.Get(traceParentHeader))
+	if err != nil {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// recordingTracer records the spans it starts, numbering them from 1.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name   string
+	sc     SpanContext
+	parent SpanContext
+	attrs  map[string]string
+	ended  bool
+	err    error
+}
+
+func (t *recordingTracer) StartSpan(name string, parent SpanContext) Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &recordedSpan{name: name, parent: parent, attrs: map[string]string{}}
+	s.sc.TraceID = parent.TraceID
+	if !parent.IsValid() {
+		s.sc.TraceID = [16]byte{15: 1}
+	}
+	binary.BigEndian.PutUint64(s.sc.SpanID[:], uint64(len(t.spans)+1))
+	s.sc.Sampled = true
+	t.spans = append(t.spans, s)
+	return &recordingSpan{t, s}
+}
+
+// find returns the first span named name.
+func (t *recordingTracer) find(name string) *recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+type recordingSpan struct {
+	t *recordingTracer
+	s *recordedSpan
+}
+
+func (s *recordingSpan) SpanContext() SpanContext { return s.s.sc }
+
+func (s *recordingSpan) SetAttribute(key, value string) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.s.attrs[key] = value
+}
+
+func (s *recordingSpan) End(err error) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.s.ended, s.s.err = true, err
+}
+
+func TestTracePropagation(t *testing.T) {
+	serverTracer := new(recordingTracer)
+	server := NewRegistry()
+	serverPool := server.NewHTTPPool("http://server")
+	sg := server.NewGroup("trace-test", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("value:" + key)
+	}))
+	sg.Tracer = serverTracer
+	srv := httptest.NewServer(serverPool)
+	defer srv.Close()
+
+	clientTracer := new(recordingTracer)
+	client := NewRegistry()
+	client.NewHTTPPool("http://client").Set(srv.URL)
+	cg := client.NewGroup("trace-test", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		t.Error("client Getter called")
+		return dest.SetString("")
+	}))
+	cg.Tracer = clientTracer
+
+	var s string
+	if err := cg.Get(context.Background(), "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+
+	get := clientTracer.find("groupcache.Get")
+	if get == nil || get.parent.IsValid() || !get.ended || get.attrs["key"] != "key" {
+		t.Fatalf("client Get span = %+v; want an ended root span for key", get)
+	}
+	lookup := clientTracer.find("groupcache.lookup")
+	if lookup == nil || lookup.parent != get.sc || lookup.attrs["hit"] != "false" {
+		t.Errorf("client lookup span = %+v; want a missing child of Get", lookup)
+	}
+	wait := clientTracer.find("groupcache.singleflight")
+	if wait == nil || wait.parent != get.sc || wait.attrs["leader"] != "true" {
+		t.Errorf("client singleflight span = %+v; want a leading child of Get", wait)
+	}
+	fetch := clientTracer.find("groupcache.peer_fetch")
+	if fetch == nil || wait == nil || fetch.parent != wait.sc || fetch.attrs["peer"] != srv.URL || !fetch.ended || fetch.err != nil {
+		t.Fatalf("client peer_fetch span = %+v; want a successful child of singleflight", fetch)
+	}
+	if clientTracer.find("groupcache.local_load") != nil {
+		t.Error("client recorded a local_load span")
+	}
+
+	remote := serverTracer.find("groupcache.Get")
+	if remote == nil || remote.parent != fetch.sc {
+		t.Fatalf("server Get span = %+v; want a child of the client's peer_fetch %+v", remote, fetch.sc)
+	}
+	if remote.sc.TraceID != get.sc.TraceID {
+		t.Error("server span isn't in the client's trace")
+	}
+	load := serverTracer.find("groupcache.local_load")
+	if load == nil || load.sc.TraceID != get.sc.TraceID || !load.ended {
+		t.Errorf("server local_load span = %+v; want an ended span in the client's trace", load)
+	}
+
+	// Without a Tracer, a caller's span context is passed on as is.
+	cg.Tracer = nil
+	sc := SpanContext{TraceID: [16]byte{0: 9}, SpanID: [8]byte{0: 9}}
+	if err := cg.Get(ContextWithSpanContext(context.Background(), sc), "other", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	var got *recordedSpan
+	serverTracer.mu.Lock()
+	for _, s := range serverTracer.spans {
+		if s.name == "groupcache.Get" && s.attrs["key"] == "other" {
+			got = s
+		}
+	}
+	serverTracer.mu.Unlock()
+	if got == nil || got.parent != sc {
+		t.Errorf("server Get span = %+v; want a child of the caller's %+v", got, sc)
+	}
+}
+
+// tests that a pool whose Context func returns nil, as older ones
+// may, serves traced requests with the request's context.
+func TestTraceNilPoolContext(t *testing.T) {
+	tracer := new(recordingTracer)
+	r := NewRegistry()
+	pool := r.NewHTTPPool("http://server")
+	pool.Context = func(*http.Request) Context { return nil }
+	g := r.NewGroup("trace-nil-context", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("value")
+	}))
+	g.Tracer = tracer
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+
+	sc := SpanContext{TraceID: [16]byte{0: 7}, SpanID: [8]byte{0: 7}, Sampled: true}
+	for method, rpc := range map[string]string{"GET": "", "DELETE": removeRPC} {
+		req, err := http.NewRequest(method, srv.URL+defaultBasePath+rpcPath(rpc, "trace-nil-context", "key"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(traceParentHeader, sc.TraceParent())
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %s", method, res.Status)
+		}
+	}
+	if get := tracer.find("groupcache.Get"); get == nil || get.parent != sc {
+		t.Errorf("server Get span = %+v; want a child of %+v", get, sc)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceParent(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Sampled || sc.TraceID[0] != 0x4b || sc.SpanID[7] != 0xb7 {
+		t.Errorf("ParseTraceParent(%q) = %+v", valid, sc)
+	}
+	if got := sc.TraceParent(); got != valid {
+		t.Errorf("TraceParent() = %q; want %q", got, valid)
+	}
+	if _, err := ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra"); err != nil {
+		t.Errorf("future version: %v", err)
+	}
+	for _, s := range []string{
+		"",
+		valid + "-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0g",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if sc, err := ParseTraceParent(s); err == nil {
+			t.Errorf("ParseTraceParent(%q) = %+v; want error", s, sc)
+		}
+	}
+}